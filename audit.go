@@ -0,0 +1,348 @@
+package ecpay
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AuditKind string
+
+const (
+	AuditRequest  AuditKind = "request"
+	AuditResponse AuditKind = "response"
+	AuditCallback AuditKind = "callback"
+)
+
+// AuditRecord is one entry of the audit log. Hash covers every other field,
+// PrevHash included, so changing, removing or reordering records breaks
+// the chain. Secrets and card data are masked, CheckMacValue is kept.
+type AuditRecord struct {
+	Seq             uint64            `json:"seq"`
+	Time            time.Time         `json:"time"`
+	Kind            AuditKind         `json:"kind"`
+	RequestID       string            `json:"request_id,omitempty"`
+	Operation       string            `json:"operation,omitempty"`
+	Endpoint        string            `json:"endpoint,omitempty"`
+	MerchantTradeNo string            `json:"merchant_trade_no,omitempty"`
+	Params          map[string]string `json:"params,omitempty"`
+	StatusCode      int               `json:"status_code,omitempty"`
+	Body            string            `json:"body,omitempty"`
+	RtnCode         string            `json:"rtn_code,omitempty"`
+	// Outcome is the NotifyOutcome of callbacks.
+	Outcome  string `json:"outcome,omitempty"`
+	KeyID    string `json:"key_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+func (r AuditRecord) computeHash() (string, error) {
+	r.Hash = ""
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type AuditSink interface {
+	WriteAudit(ctx context.Context, record AuditRecord) error
+}
+
+// AuditHead is implemented by sinks that already hold records, the chain
+// continues from the last one.
+type AuditHead interface {
+	LastAuditRecord() (AuditRecord, bool)
+}
+
+var ErrAuditFailed = errors.New("audit record not written")
+
+type AuditOption func(*AuditLog)
+
+// WithAuditErrorHandler is called when a response or callback record cannot
+// be written. Requests are not sent when their record cannot be written.
+func WithAuditErrorHandler(fn func(ctx context.Context, record AuditRecord, err error)) AuditOption {
+	return func(a *AuditLog) {
+		a.onError = fn
+	}
+}
+
+// AuditLog chains records and writes them to a sink. Attach it to clients
+// with WithMiddleware(a.Middleware()) and to notify handlers with
+// WithNotifyMiddleware(a.NotifyMiddleware()).
+// Use a single AuditLog per sink, several logs appending to one sink fork
+// the chain.
+type AuditLog struct {
+	sink    AuditSink
+	onError func(ctx context.Context, record AuditRecord, err error)
+
+	mu       sync.Mutex
+	secrets  []string
+	seq      uint64
+	lastHash string
+}
+
+// NewAuditLog masks the secrets of every config in every record, pass the
+// configs of all merchants sharing the log.
+func NewAuditLog(configs []EcpayConfig, sink AuditSink, opts ...AuditOption) *AuditLog {
+	a := &AuditLog{
+		sink:    sink,
+		onError: func(ctx context.Context, record AuditRecord, err error) {},
+	}
+	for _, config := range configs {
+		a.secrets = append(a.secrets, config.secrets()...)
+	}
+	if head, ok := sink.(AuditHead); ok {
+		if last, ok := head.LastAuditRecord(); ok {
+			a.seq = last.Seq
+			a.lastHash = last.Hash
+		}
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// AddConfig masks the secrets of config from now on, e.g. for a merchant
+// registered after the log was created.
+func (a *AuditLog) AddConfig(config EcpayConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.secrets = append(a.secrets, config.secrets()...)
+}
+
+func isAuditSensitiveField(key string) bool {
+	return isSensitiveField(key) && !strings.EqualFold(key, "CheckMacValue")
+}
+
+// Record chains record to the previous one and writes it. The chain only
+// advances when the sink accepted the record.
+func (a *AuditLog) Record(ctx context.Context, record AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	r := redactor{secrets: a.secrets, sensitive: isAuditSensitiveField}
+	if record.Params != nil {
+		record.Params = r.params(record.Params)
+	}
+	record.Body = r.body(record.Body)
+	record.Error = r.string(record.Error)
+
+	record.Seq = a.seq + 1
+	record.Time = time.Now().UTC()
+	record.PrevHash = a.lastHash
+	hash, err := record.computeHash()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAuditFailed, err)
+	}
+	record.Hash = hash
+	if err := a.sink.WriteAudit(ctx, record); err != nil {
+		return fmt.Errorf("%w: %v", ErrAuditFailed, err)
+	}
+	a.seq = record.Seq
+	a.lastHash = record.Hash
+	return nil
+}
+
+func (a *AuditLog) Middleware() Middleware {
+	return func(next Invoker) Invoker {
+		return func(ctx context.Context, call *Call) error {
+			record := AuditRecord{
+				RequestID:       RequestIDFromContext(ctx),
+				Operation:       call.Operation,
+				Endpoint:        call.Endpoint,
+				MerchantTradeNo: call.MerchantTradeNo,
+			}
+			request := record
+			request.Kind = AuditRequest
+			request.Params = call.Params
+			if err := a.Record(ctx, request); err != nil {
+				return err
+			}
+
+			err := next(ctx, call)
+			response := record
+			response.Kind = AuditResponse
+			response.StatusCode = call.StatusCode
+			response.Body = call.Body
+			response.RtnCode = call.RtnCode
+			if err != nil {
+				response.Error = err.Error()
+			}
+			if auditErr := a.Record(ctx, response); auditErr != nil {
+				a.onError(ctx, response, auditErr)
+			}
+			return err
+		}
+	}
+}
+
+func (a *AuditLog) NotifyMiddleware() NotifyMiddleware {
+	return func(next NotifyInvoker) NotifyInvoker {
+		return func(ctx context.Context, n *Notification) error {
+			err := next(ctx, n)
+			record := AuditRecord{
+				Kind:            AuditCallback,
+				RequestID:       RequestIDFromContext(ctx),
+				Endpoint:        string(n.Type),
+				MerchantTradeNo: n.MerchantTradeNo,
+				Body:            n.Body,
+				RtnCode:         n.RtnCode,
+				Outcome:         string(n.Outcome),
+				KeyID:           n.KeyID,
+			}
+			if err != nil {
+				record.Error = err.Error()
+			}
+			if auditErr := a.Record(ctx, record); auditErr != nil {
+				a.onError(ctx, record, auditErr)
+			}
+			return err
+		}
+	}
+}
+
+var ErrAuditChainBroken = errors.New("audit chain broken")
+
+type AuditChainError struct {
+	Line   int
+	Seq    uint64
+	Reason string
+}
+
+func (e *AuditChainError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%v (seq %d): %v", ErrAuditChainBroken, e.Seq, e.Reason)
+	}
+	return fmt.Sprintf("%v at line %d (seq %d): %v", ErrAuditChainBroken, e.Line, e.Seq, e.Reason)
+}
+
+func (e *AuditChainError) Unwrap() error {
+	return ErrAuditChainBroken
+}
+
+// VerifyAuditLog checks a JSON-lines audit log from its first record and
+// returns the number of records in it. head is the Hash of the last record
+// as kept elsewhere, e.g. from AuditFile.LastAuditRecord; when set, a log
+// cut short at the end fails too. An empty head skips that check.
+func VerifyAuditLog(r io.Reader, head string) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var prev *AuditRecord
+	count := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return count, &AuditChainError{Line: line, Reason: err.Error()}
+		}
+		hash, err := record.computeHash()
+		if err != nil {
+			return count, err
+		}
+		if hash != record.Hash {
+			return count, &AuditChainError{Line: line, Seq: record.Seq, Reason: "hash mismatch"}
+		}
+		if prev == nil && (record.Seq != 1 || record.PrevHash != "") {
+			return count, &AuditChainError{Line: line, Seq: record.Seq, Reason: "log does not start with the first record"}
+		}
+		if prev != nil && record.PrevHash != prev.Hash {
+			return count, &AuditChainError{Line: line, Seq: record.Seq, Reason: "previous hash mismatch"}
+		}
+		if prev != nil && record.Seq != prev.Seq+1 {
+			return count, &AuditChainError{Line: line, Seq: record.Seq, Reason: fmt.Sprintf("expected seq %d", prev.Seq+1)}
+		}
+		prev = &record
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+	if head != "" && (prev == nil || prev.Hash != head) {
+		seq := uint64(0)
+		if prev != nil {
+			seq = prev.Seq
+		}
+		return count, &AuditChainError{Seq: seq, Reason: "log does not end with the expected head"}
+	}
+	return count, nil
+}
+
+// AuditFile is an AuditSink appending JSON lines to a file, synced after
+// every record.
+type AuditFile struct {
+	mu   sync.Mutex
+	file *os.File
+	last *AuditRecord
+}
+
+// OpenAuditFile appends to path, continuing the chain of the records it
+// already holds.
+func OpenAuditFile(path string) (*AuditFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	f := &AuditFile{file: file}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("read audit file %v: %w", path, err)
+		}
+		f.last = &record
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("read audit file %v: %w", path, err)
+	}
+	return f, nil
+}
+
+func (f *AuditFile) WriteAudit(ctx context.Context, record AuditRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	f.last = &record
+	return nil
+}
+
+func (f *AuditFile) LastAuditRecord() (AuditRecord, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.last == nil {
+		return AuditRecord{}, false
+	}
+	return *f.last, true
+}
+
+func (f *AuditFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,121 @@
+package ecpay_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+func TestVerifyAuditLog(t *testing.T) {
+	config := testConfig()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	file, err := ecpay.OpenAuditFile(path)
+	if err != nil {
+		t.Fatalf("OpenAuditFile: %v", err)
+	}
+	audit := ecpay.NewAuditLog([]ecpay.EcpayConfig{config}, file)
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+	ec := srv.Client(ecpay.WithMiddleware(audit.Middleware()))
+
+	if _, err := ec.CreateShipOrder(shipOrderConfig("S1")); err != nil {
+		t.Fatalf("CreateShipOrder: %v", err)
+	}
+	if _, err := ec.QueryShip(ecpay.QueryShipConfig{MerchantTradeNo: "S1"}); err != nil {
+		t.Fatalf("QueryShip: %v", err)
+	}
+	last, ok := file.LastAuditRecord()
+	if !ok {
+		t.Fatal("no audit record written")
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if bytes.Contains(data, []byte(config.HashKey)) {
+		t.Error("audit log contains the HashKey")
+	}
+
+	count, err := ecpay.VerifyAuditLog(bytes.NewReader(data), last.Hash)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("VerifyAuditLog count = %d, want 4", count)
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"last record cut", bytes.Join(lines[:len(lines)-2], nil)},
+		{"first record cut", bytes.Join(lines[1:], nil)},
+		{"record removed", bytes.Join(append(append([][]byte{}, lines[:1]...), lines[2:]...), nil)},
+		{"record edited", bytes.Replace(data, []byte(`"merchant_trade_no":"S1"`), []byte(`"merchant_trade_no":"S2"`), 1)},
+	}
+	for _, test := range tests {
+		_, err := ecpay.VerifyAuditLog(bytes.NewReader(test.data), last.Hash)
+		if !errors.Is(err, ecpay.ErrAuditChainBroken) {
+			t.Errorf("%v: VerifyAuditLog error = %v, want ErrAuditChainBroken", test.name, err)
+		}
+	}
+}
+
+func TestAuditLogSharedByMerchants(t *testing.T) {
+	first := testConfig()
+	second := testConfig()
+	second.MerchantID = "2000132"
+	second.HashKey = "5294y06JbISpM5x9"
+	second.HashIV = "v77hoKGq4kWxNNIS"
+	path := filepath.Join(t.TempDir(), "audit.log")
+	file, err := ecpay.OpenAuditFile(path)
+	if err != nil {
+		t.Fatalf("OpenAuditFile: %v", err)
+	}
+	defer file.Close()
+	audit := ecpay.NewAuditLog([]ecpay.EcpayConfig{first}, file)
+	audit.AddConfig(second)
+
+	for _, config := range []ecpay.EcpayConfig{first, second} {
+		srv := ecpaytest.NewServer(config)
+		defer srv.Close()
+		if _, err := srv.Client(ecpay.WithMiddleware(audit.Middleware())).CreateShipOrder(shipOrderConfig("S1")); err != nil {
+			t.Fatalf("CreateShipOrder for %v: %v", config.MerchantID, err)
+		}
+	}
+	handler := ecpay.NewPaymentNotifyHandler(second, func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+		return nil
+	}, ecpay.WithNotifyMiddleware(audit.NotifyMiddleware()))
+	postNotification(handler, notification(second, map[string]string{"card4no": "4321"}))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	last, _ := file.LastAuditRecord()
+	count, err := ecpay.VerifyAuditLog(bytes.NewReader(data), last.Hash)
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if count != 5 {
+		t.Errorf("VerifyAuditLog count = %d, want 5", count)
+	}
+	for _, leaked := range []string{first.HashKey, second.HashKey, second.HashIV, "4321"} {
+		if bytes.Contains(data, []byte(leaked)) {
+			t.Errorf("audit log contains %q", leaked)
+		}
+	}
+	if !bytes.Contains(data, []byte(`"kind":"callback"`)) {
+		t.Error("notification not audited")
+	}
+}
@@ -0,0 +1,99 @@
+package ecpay
+
+import (
+	"context"
+	"sync"
+)
+
+const defaultBatchWorkers = 4
+
+type BatchOptions struct {
+	// Workers is the number of concurrent queries, defaults to 4. The
+	// client's RateLimits and MaxInFlight still apply.
+	Workers int
+	// MerchantID is the sub-merchant queried in platform mode, defaults to
+	// EcpayConfig.MerchantID.
+	MerchantID string
+}
+
+type PaymentQueryResult struct {
+	// Index is the position of MerchantTradeNo in the input.
+	Index           int
+	MerchantTradeNo string
+	Payment         *PaymentResponse
+	Err             error
+}
+
+type ShipQueryResult struct {
+	Index           int
+	MerchantTradeNo string
+	Shipment        ShipOrderResponse
+	Err             error
+}
+
+// QueryPaymentsStream queries every trade and sends each result as soon as
+// it is known. A failed query does not stop the others; cancel ctx to stop
+// the batch. The channel is closed when all trades are done and must be
+// drained.
+func QueryPaymentsStream(ctx context.Context, ec EcpayContext, merchantTradeNos []string, opts BatchOptions) <-chan PaymentQueryResult {
+	return runBatch(ctx, merchantTradeNos, opts, func(ctx context.Context, index int, merchantTradeNo string) PaymentQueryResult {
+		payment, err := ec.QueryPaymentContext(ctx, QueryConfig{MerchantID: opts.MerchantID, MerchantTradeNo: merchantTradeNo})
+		return PaymentQueryResult{Index: index, MerchantTradeNo: merchantTradeNo, Payment: payment, Err: err}
+	})
+}
+
+// QueryPayments collects QueryPaymentsStream in input order.
+func QueryPayments(ctx context.Context, ec EcpayContext, merchantTradeNos []string, opts BatchOptions) []PaymentQueryResult {
+	results := make([]PaymentQueryResult, len(merchantTradeNos))
+	for result := range QueryPaymentsStream(ctx, ec, merchantTradeNos, opts) {
+		results[result.Index] = result
+	}
+	return results
+}
+
+func QueryShipsStream(ctx context.Context, ec EcpayContext, merchantTradeNos []string, opts BatchOptions) <-chan ShipQueryResult {
+	return runBatch(ctx, merchantTradeNos, opts, func(ctx context.Context, index int, merchantTradeNo string) ShipQueryResult {
+		shipment, err := ec.QueryShipContext(ctx, QueryShipConfig{MerchantID: opts.MerchantID, MerchantTradeNo: merchantTradeNo})
+		return ShipQueryResult{Index: index, MerchantTradeNo: merchantTradeNo, Shipment: shipment, Err: err}
+	})
+}
+
+func QueryShips(ctx context.Context, ec EcpayContext, merchantTradeNos []string, opts BatchOptions) []ShipQueryResult {
+	results := make([]ShipQueryResult, len(merchantTradeNos))
+	for result := range QueryShipsStream(ctx, ec, merchantTradeNos, opts) {
+		results[result.Index] = result
+	}
+	return results
+}
+
+func runBatch[T any](ctx context.Context, merchantTradeNos []string, opts BatchOptions, query func(ctx context.Context, index int, merchantTradeNo string) T) <-chan T {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultBatchWorkers
+	}
+	if workers > len(merchantTradeNos) {
+		workers = len(merchantTradeNos)
+	}
+
+	indexes := make(chan int)
+	results := make(chan T)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results <- query(ctx, index, merchantTradeNos[index])
+			}
+		}()
+	}
+	go func() {
+		for index := range merchantTradeNos {
+			indexes <- index
+		}
+		close(indexes)
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
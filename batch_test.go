@@ -0,0 +1,54 @@
+package ecpay_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+func TestQueryShipsPartialFailure(t *testing.T) {
+	srv := ecpaytest.NewServer(testConfig())
+	defer srv.Close()
+	ec := srv.Client()
+	for _, merchantTradeNo := range []string{"S1", "S3"} {
+		if _, err := ec.CreateShipOrder(shipOrderConfig(merchantTradeNo)); err != nil {
+			t.Fatalf("CreateShipOrder: %v", err)
+		}
+	}
+
+	results := ecpay.QueryShips(context.Background(), ec, []string{"S1", "S2", "S3"}, ecpay.BatchOptions{Workers: 2})
+	if len(results) != 3 {
+		t.Fatalf("%d results, want 3", len(results))
+	}
+	for i, want := range []string{"S1", "S2", "S3"} {
+		if results[i].Index != i || results[i].MerchantTradeNo != want {
+			t.Errorf("result %d = %d %v, want %d %v", i, results[i].Index, results[i].MerchantTradeNo, i, want)
+		}
+	}
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Errorf("existing shipments failed: %v, %v", results[0].Err, results[2].Err)
+	}
+	if results[0].Shipment.MerchantTradeNo != "S1" || results[2].Shipment.MerchantTradeNo != "S3" {
+		t.Errorf("shipments = %v, %v", results[0].Shipment.MerchantTradeNo, results[2].Shipment.MerchantTradeNo)
+	}
+	if results[1].Err == nil {
+		t.Error("unknown shipment S2 did not fail")
+	}
+}
+
+func TestQueryPaymentsCancelled(t *testing.T) {
+	srv := ecpaytest.NewServer(testConfig())
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := ecpay.QueryPayments(ctx, srv.Client(), []string{"T1", "T2"}, ecpay.BatchOptions{})
+	for _, result := range results {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("%v: error = %v, want context.Canceled", result.MerchantTradeNo, result.Err)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package ecpay
 import (
 	"crypto/md5"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/url"
 	"sort"
@@ -54,6 +56,67 @@ func (c *paymentMacService) GenerateCheckMacValue(params map[string]string) stri
 	return checkMac
 }
 
+var ErrInvalidCheckMacValue = errors.New("invalid CheckMacValue")
+
+// VerifyCheckMacValue checks the CheckMacValue carried by values, as posted
+// by ECPay to ServerReplyURL or ReturnURL.
+func VerifyCheckMacValue(service CheckMacValueService, values url.Values) error {
+	params := make(map[string]string, len(values))
+	for key := range values {
+		if key == "CheckMacValue" {
+			continue
+		}
+		params[key] = values.Get(key)
+	}
+	expected := service.GenerateCheckMacValue(params)
+	actual := strings.ToUpper(values.Get("CheckMacValue"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+		return ErrInvalidCheckMacValue
+	}
+	return nil
+}
+
+type KeyPair struct {
+	ID      string
+	HashKey string
+	HashIV  string
+}
+
+const PrimaryKeyID = "primary"
+
+// keyPairs lists the primary keys first.
+func (c EcpayConfig) keyPairs() []KeyPair {
+	id := c.KeyID
+	if id == "" {
+		id = PrimaryKeyID
+	}
+	return append([]KeyPair{{ID: id, HashKey: c.HashKey, HashIV: c.HashIV}}, c.AdditionalKeys...)
+}
+
+// VerifyPaymentCheckMacValue tries the primary keys of config and then its
+// AdditionalKeys, and returns the ID of the pair that matched.
+func VerifyPaymentCheckMacValue(config EcpayConfig, values url.Values) (string, error) {
+	return verifyWithKeys(config, values, NewPaymentMacValue)
+}
+
+// VerifyShipCheckMacValue is VerifyPaymentCheckMacValue for logistics
+// callbacks.
+func VerifyShipCheckMacValue(config EcpayConfig, values url.Values) (string, error) {
+	return verifyWithKeys(config, values, NewShipMacValue)
+}
+
+func verifyWithKeys(config EcpayConfig, values url.Values, newService func(EcpayConfig) CheckMacValueService) (string, error) {
+	for _, key := range config.keyPairs() {
+		keyConfig := config
+		keyConfig.HashKey = key.HashKey
+		keyConfig.HashIV = key.HashIV
+		if VerifyCheckMacValue(newService(keyConfig), values) == nil {
+			return key.ID, nil
+		}
+	}
+	return "", ErrInvalidCheckMacValue
+}
+
 func FormUrlEncode(s string) string {
 	s = url.QueryEscape(s)
 	s = strings.ReplaceAll(s, "%21", "!")
@@ -0,0 +1,65 @@
+package ecpay_test
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+func TestVerifyPaymentCheckMacValueKeys(t *testing.T) {
+	config := testConfig()
+	config.KeyID = "current"
+	config.AdditionalKeys = []ecpay.KeyPair{{ID: "previous", HashKey: "5294y06JbISpM5x9", HashIV: "v77hoKGq4kWxNNIS"}}
+	previous := testConfig()
+	previous.HashKey = "5294y06JbISpM5x9"
+	previous.HashIV = "v77hoKGq4kWxNNIS"
+	unknown := testConfig()
+	unknown.HashKey = "XBERn1YOvpM9nfZc"
+	unknown.HashIV = "h1ONHk4P4yqbl5LK"
+
+	tests := []struct {
+		name   string
+		signer ecpay.EcpayConfig
+		keyID  string
+		err    error
+	}{
+		{"primary keys", testConfig(), "current", nil},
+		{"additional keys", previous, "previous", nil},
+		{"unknown keys", unknown, "", ecpay.ErrInvalidCheckMacValue},
+	}
+	for _, test := range tests {
+		values, err := url.ParseQuery(notification(test.signer, nil))
+		if err != nil {
+			t.Fatalf("ParseQuery: %v", err)
+		}
+		keyID, err := ecpay.VerifyPaymentCheckMacValue(config, values)
+		if keyID != test.keyID || !errors.Is(err, test.err) {
+			t.Errorf("%v: VerifyPaymentCheckMacValue = %q, %v, want %q, %v", test.name, keyID, err, test.keyID, test.err)
+		}
+	}
+}
+
+func TestPaymentNotifyAdditionalKey(t *testing.T) {
+	old := testConfig()
+	rotated := testConfig()
+	rotated.HashKey = "5294y06JbISpM5x9"
+	rotated.HashIV = "v77hoKGq4kWxNNIS"
+	rotated.AdditionalKeys = []ecpay.KeyPair{{ID: "previous", HashKey: old.HashKey, HashIV: old.HashIV}}
+
+	var notified <-chan *ecpay.PaymentResponse
+	old.PaymentServerReplyURL, notified = notifyServer(t, rotated)
+	// The server still signs with the keys that were replaced.
+	srv := ecpaytest.NewServer(old)
+	defer srv.Close()
+
+	resp := pay(t, srv, srv.Client(), "T1", 100, notified)
+	if resp.KeyID != "previous" {
+		t.Errorf("KeyID = %q, want previous", resp.KeyID)
+	}
+	if resp.Status != ecpay.PaymentStatusPaid {
+		t.Errorf("Status = %v, want %v", resp.Status, ecpay.PaymentStatusPaid)
+	}
+}
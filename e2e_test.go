@@ -0,0 +1,163 @@
+package ecpay_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+func testConfig() ecpay.EcpayConfig {
+	return ecpay.EcpayConfig{
+		MerchantID:     "3002607",
+		HashKey:        "pwFHCqoQZGmho4w6",
+		HashIV:         "EkRm7iFT261dpevs",
+		CreditCheckKey: "creditcheck",
+		SenderName:     "sender",
+		SenderPhone:    "0912345678",
+	}
+}
+
+func shipOrderConfig(merchantTradeNo string) ecpay.CreateShippingOrderConfig {
+	return ecpay.CreateShippingOrderConfig{
+		MerchantTradeNo:   merchantTradeNo,
+		TradeDate:         time.Now(),
+		ShippingStoreType: "711",
+		Amount:            100,
+		EntreeName:        "goods",
+		ReceiverName:      "receiver",
+		ReceiverPhone:     "0911111111",
+		ReceiverStoreID:   "131386",
+	}
+}
+
+// pay creates a credit card payment, pays it and returns the notification.
+func pay(t *testing.T, srv *ecpaytest.Server, ec ecpay.Ecpay, merchantTradeNo string, amount ecpay.Money, notified <-chan *ecpay.PaymentResponse) *ecpay.PaymentResponse {
+	t.Helper()
+	ctx := context.Background()
+	html, err := ec.CreatePaymentOrder(ecpay.PaymentConfig{
+		MerchantTradeNo: merchantTradeNo,
+		TradeDate:       time.Now(),
+		Amount:          amount,
+		EntreeName:      "goods",
+		SupportPayments: []string{"Credit"},
+	})
+	if err != nil {
+		t.Fatalf("CreatePaymentOrder: %v", err)
+	}
+	if err := srv.SubmitForm(ctx, html); err != nil {
+		t.Fatalf("SubmitForm: %v", err)
+	}
+	if err := srv.Pay(ctx, merchantTradeNo, "Credit_CreditCard"); err != nil {
+		t.Fatalf("Pay: %v", err)
+	}
+	select {
+	case resp := <-notified:
+		return resp
+	case <-time.After(5 * time.Second):
+		t.Fatal("no payment notification")
+		return nil
+	}
+}
+
+func notifyServer(t *testing.T, config ecpay.EcpayConfig, opts ...ecpay.NotifyOption) (string, <-chan *ecpay.PaymentResponse) {
+	t.Helper()
+	notified := make(chan *ecpay.PaymentResponse, 1)
+	handler := ecpay.NewPaymentNotifyHandler(config, func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+		notified <- resp
+		return nil
+	}, opts...)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv.URL, notified
+}
+
+// shipNotifyServer verifies logistics callbacks and passes them on.
+func shipNotifyServer(t *testing.T, config ecpay.EcpayConfig) (string, <-chan ecpay.ShipOrderResponse) {
+	t.Helper()
+	notified := make(chan ecpay.ShipOrderResponse, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "0|"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := ecpay.VerifyShipCheckMacValue(config, r.PostForm); err != nil {
+			t.Errorf("logistics callback: %v", err)
+			http.Error(w, "0|"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := ecpay.NewEcpay(config).ParseShipOrderResponse(r.PostForm.Encode())
+		if err != nil {
+			t.Errorf("logistics callback: %v", err)
+		}
+		notified <- resp
+		io.WriteString(w, "1|OK")
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, notified
+}
+
+func TestServerPaymentFlow(t *testing.T) {
+	config := testConfig()
+	var notified <-chan *ecpay.PaymentResponse
+	config.PaymentServerReplyURL, notified = notifyServer(t, config)
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+	ec := srv.Client()
+
+	paid := pay(t, srv, ec, "T1", 150, notified)
+	if paid.Status != ecpay.PaymentStatusPaid || paid.Amount != 150 {
+		t.Errorf("notification = %v %v, want %v 150", paid.Status, paid.Amount, ecpay.PaymentStatusPaid)
+	}
+	query, err := ec.QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "T1"})
+	if err != nil {
+		t.Fatalf("QueryPayment: %v", err)
+	}
+	if query.Status != ecpay.PaymentStatusPaid || query.Amount != 150 {
+		t.Errorf("query = %v %v, want %v 150", query.Status, query.Amount, ecpay.PaymentStatusPaid)
+	}
+	if p, ok := srv.Payment("T1"); !ok || p.TradeStatus != "1" {
+		t.Errorf("server payment = %+v, want TradeStatus 1", p)
+	}
+}
+
+func TestServerShipmentFlow(t *testing.T) {
+	config := testConfig()
+	var notified <-chan ecpay.ShipOrderResponse
+	config.ShipServerReplyURL, notified = shipNotifyServer(t, config)
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+	ec := srv.Client()
+
+	body, err := ec.CreateShipOrder(shipOrderConfig("S1"))
+	if err != nil {
+		t.Fatalf("CreateShipOrder: %v", err)
+	}
+	created, err := ec.ParseShipOrderResponse(body)
+	if err != nil {
+		t.Fatalf("ParseShipOrderResponse: %v", err)
+	}
+	if _, err := ec.CreateShipOrder(shipOrderConfig("S1")); !errors.Is(err, ecpay.ErrDuplicateCreateShip) {
+		t.Errorf("duplicate CreateShipOrder error = %v, want ErrDuplicateCreateShip", err)
+	}
+
+	if err := srv.NotifyShipment(context.Background(), "S1", "2068"); err != nil {
+		t.Fatalf("NotifyShipment: %v", err)
+	}
+	if resp := <-notified; resp.Status != ecpay.SELLER_SEND_TO_STORE {
+		t.Errorf("callback status = %v, want %v", resp.Status, ecpay.SELLER_SEND_TO_STORE)
+	}
+	query, err := ec.QueryShip(ecpay.QueryShipConfig{MerchantTradeNo: "S1"})
+	if err != nil {
+		t.Fatalf("QueryShip: %v", err)
+	}
+	if query.LogisticsID != created.LogisticsID || query.Status != ecpay.SELLER_SEND_TO_STORE {
+		t.Errorf("query = %v %v, want %v %v", query.LogisticsID, query.Status, created.LogisticsID, ecpay.SELLER_SEND_TO_STORE)
+	}
+}
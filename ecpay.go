@@ -1,9 +1,11 @@
 package ecpay
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -12,8 +14,8 @@ import (
 	"github.com/imroc/req/v3"
 )
 
-const shipStagingURL = "https://logistics-stage.ecpay.com.tw/"
-const shipProductionURL = "https://logistics.ecpay.com.tw/"
+const shipStagingURL = "https://logistics-stage.ecpay.com.tw"
+const shipProductionURL = "https://logistics.ecpay.com.tw"
 
 const paymentStagingURL = "https://payment-stage.ecpay.com.tw"
 const paymentProductionURL = "https://payment.ecpay.com.tw"
@@ -24,15 +26,40 @@ type EcpayConfig struct {
 	HashIV         string
 	CreditCheckKey string
 	IsProduction   bool
+	// PlatformID turns on platform mode: HashKey and HashIV are the keys
+	// of the platform, requests carry PlatformID and act for MerchantID or
+	// the sub-merchant named per call.
+	PlatformID string
+
+	// KeyID names HashKey and HashIV in verification results, defaults to
+	// PrimaryKeyID.
+	KeyID string
+	// AdditionalKeys are accepted when verifying callbacks but never used
+	// for signing, so keys can be rotated while ECPay still sends
+	// notifications signed with the old ones.
+	AdditionalKeys []KeyPair
 
 	SenderName         string
 	SenderPhone        string
 	ShipServerReplyURL string
 
 	PaymentServerReplyURL string
+	SimulatedPayment      SimulatedPaymentPolicy
+
+	// Timeout bounds every request to ECPay, defaults to 30 seconds.
+	// Deadlines of the context passed to the *Context methods apply too.
+	Timeout time.Duration
+	Retry   RetryPolicy
+
+	RateLimits RateLimits
+	// MaxInFlight caps concurrent requests to ECPay, 0 means unlimited.
+	MaxInFlight int
 }
 
 type ChooseShipStoreConfig struct {
+	// MerchantID is the sub-merchant in platform mode, defaults to
+	// EcpayConfig.MerchantID. The same goes for the other call configs.
+	MerchantID        string
 	MerchantTradeNo   string
 	ShippingStoreType string
 	NeedPayment       bool
@@ -52,10 +79,11 @@ type ChooseShipStoreResponse struct {
 }
 
 type CreateShippingOrderConfig struct {
+	MerchantID        string
 	MerchantTradeNo   string
 	TradeDate         time.Time
 	ShippingStoreType string
-	Amount            float32
+	Amount            Money
 	NeedPayment       bool
 	EntreeName        string
 	ReceiverName      string
@@ -72,16 +100,17 @@ type ShipOrderResponse struct {
 	RtnMsg            string
 	LogisticsID       string
 	ShippingStoreType string
-	GoodsAmount       string
+	GoodsAmount       Money
 	UpdateStatusDate  string
 	CSVNo             string
 	Status            string
 }
 
 type PaymentConfig struct {
+	MerchantID      string
 	MerchantTradeNo string
 	TradeDate       time.Time
-	Amount          float32
+	Amount          Money
 	EntreeName      string
 	SupportPayments []string
 	StoreID         string
@@ -89,6 +118,7 @@ type PaymentConfig struct {
 }
 
 type QueryConfig struct {
+	MerchantID      string
 	MerchantTradeNo string
 }
 
@@ -105,23 +135,163 @@ type Ecpay interface {
 	RefundPayment(config RefundConfig) (*RefundResponse, error)
 }
 
+// EcpayContext adds the context-aware and idempotent calls to Ecpay.
+type EcpayContext interface {
+	Ecpay
+
+	CreateShipOrderContext(ctx context.Context, config CreateShippingOrderConfig) (string, error)
+	CreateShipOrderIdempotent(config CreateShippingOrderConfig) (ShipOrderResponse, error)
+	CreateShipOrderIdempotentContext(ctx context.Context, config CreateShippingOrderConfig) (ShipOrderResponse, error)
+	QueryShipContext(ctx context.Context, config QueryShipConfig) (ShipOrderResponse, error)
+	QueryPaymentContext(ctx context.Context, config QueryConfig) (*PaymentResponse, error)
+	RefundPaymentContext(ctx context.Context, config RefundConfig) (*RefundResponse, error)
+}
+
 type EcpayImpl struct {
 	EcpayConfig
 	client *req.Client
+
+	paymentURL string
+	shipURL    string
+
+	limiters map[endpointFamily]*tokenBucket
+	inFlight chan struct{}
+	logger   Logger
+
+	middlewares []Middleware
 }
 
-var ErrDuplicateCreateShip = errors.New("duplicate create ship order")
+const defaultTimeout = 30 * time.Second
 
-func NewEcpay(config EcpayConfig) Ecpay {
-	client := req.C().SetTimeout(30 * time.Second)
+func NewEcpay(config EcpayConfig, opts ...Option) *EcpayImpl {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	client := req.C().SetTimeout(timeout)
 
-	return &EcpayImpl{
+	e := &EcpayImpl{
 		EcpayConfig: config,
 		client:      client,
+		limiters:    config.RateLimits.buckets(),
+		logger:      nopLogger{},
+	}
+	if config.MaxInFlight > 0 {
+		e.inFlight = make(chan struct{}, config.MaxInFlight)
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// post sends params to endpoint, a path below baseURL, through the
+// middleware chain. parse turns the body into the call's Result.
+func (e *EcpayImpl) post(ctx context.Context, baseURL string, endpoint string, params map[string]string, parse func(body string) (interface{}, error)) error {
+	ctx, _ = ensureRequestID(ctx)
+	op := operationFromContext(ctx)
+	call := &Call{
+		Operation:       op.name,
+		Endpoint:        endpoint,
+		MerchantTradeNo: op.merchantTradeNo,
+		Params:          params,
+		Header:          http.Header{},
+	}
+	invoke := func(ctx context.Context, call *Call) error {
+		body, statusCode, err := e.send(ctx, baseURL, call)
+		call.StatusCode = statusCode
+		call.Body = body
+		if err != nil {
+			return err
+		}
+		call.Result, err = parse(body)
+		call.RtnCode = resultRtnCode(call.Result, err)
+		return err
+	}
+	return Chain(e.middlewares...)(invoke)(ctx, call)
+}
+
+// send posts params and returns the body once it looks like an API answer.
+func (e *EcpayImpl) send(ctx context.Context, baseURL string, call *Call) (string, int, error) {
+	endpoint, params := call.Endpoint, call.Params
+	requestID := RequestIDFromContext(ctx)
+	release, err := e.acquire(ctx, endpoint)
+	if err != nil {
+		return "", 0, err
+	}
+	defer release()
+
+	e.logger.DebugContext(ctx, "ecpay request",
+		"request_id", requestID,
+		"endpoint", endpoint,
+		"params", e.redactParams(params))
+	start := time.Now()
+	request := e.client.R().SetContext(ctx)
+	for key := range call.Header {
+		request.SetHeader(key, call.Header.Get(key))
+	}
+	resp, err := request.SetFormData(params).
+		SetHeader("Content-Type", "application/x-www-form-urlencoded").
+		SetHeader("Cache-Control", "no-cache").
+		Post(fmt.Sprintf("%s/%s", baseURL, endpoint))
+	duration := time.Since(start)
+	if err != nil {
+		e.logger.ErrorContext(ctx, "ecpay request failed",
+			"request_id", requestID,
+			"endpoint", endpoint,
+			"duration", duration,
+			"error", err)
+		return "", 0, err
+	}
+	body := resp.String()
+	e.logger.DebugContext(ctx, "ecpay response body",
+		"request_id", requestID,
+		"endpoint", endpoint,
+		"body", e.redactBody(body))
+	if err := classifyResponse(endpoint, resp.StatusCode, body); err != nil {
+		e.logger.ErrorContext(ctx, "ecpay unexpected response",
+			"request_id", requestID,
+			"endpoint", endpoint,
+			"status", resp.StatusCode,
+			"duration", duration,
+			"error", e.redactString(err.Error()))
+		return body, resp.StatusCode, err
+	}
+	e.logger.InfoContext(ctx, "ecpay response",
+		"request_id", requestID,
+		"endpoint", endpoint,
+		"status", resp.StatusCode,
+		"duration", duration)
+	return body, resp.StatusCode, nil
+}
+
+// postIdempotent is post retried according to the RetryPolicy. Only use it
+// for endpoints that can safely be called twice.
+func (e *EcpayImpl) postIdempotent(ctx context.Context, baseURL string, endpoint string, params map[string]string, parse func(body string) (interface{}, error)) error {
+	return e.Retry.retry(ctx, func(attempt int) error {
+		return e.post(ctx, baseURL, endpoint, params, parse)
+	})
+}
+
+// merchantID picks the sub-merchant of a call, falling back to the
+// configured MerchantID.
+func (c EcpayConfig) merchantID(override string) string {
+	if override != "" {
+		return override
+	}
+	return c.MerchantID
+}
+
+func (c EcpayConfig) setPlatformID(params map[string]string) {
+	if c.PlatformID != "" {
+		params["PlatformID"] = c.PlatformID
 	}
 }
 
 func (e *EcpayImpl) getShipURL() string {
+	if e.shipURL != "" {
+		return e.shipURL
+	}
 	if e.IsProduction {
 		return shipProductionURL
 	}
@@ -130,7 +300,7 @@ func (e *EcpayImpl) getShipURL() string {
 
 func (e *EcpayImpl) ChooseShipStore(config ChooseShipStoreConfig) (string, error) {
 	postData := map[string]string{
-		"MerchantID":       e.MerchantID,
+		"MerchantID":       e.merchantID(config.MerchantID),
 		"MerchantTradeNo":  config.MerchantTradeNo,
 		"LogisticsType":    "CVS",
 		"LogisticsSubType": FormatStoreType(config.ShippingStoreType),
@@ -139,6 +309,7 @@ func (e *EcpayImpl) ChooseShipStore(config ChooseShipStoreConfig) (string, error
 		"ExtraData":        config.Extra,
 		"Device":           FormatIsMobile(config.IsMobile),
 	}
+	e.setPlatformID(postData)
 
 	postDataHtml := ""
 	for key, value := range postData {
@@ -171,14 +342,22 @@ func (e *EcpayImpl) ChooseShipStore(config ChooseShipStoreConfig) (string, error
 }
 
 func (e *EcpayImpl) CreateShipOrder(config CreateShippingOrderConfig) (string, error) {
+	return e.CreateShipOrderContext(context.Background(), config)
+}
+
+func (e *EcpayImpl) CreateShipOrderContext(ctx context.Context, config CreateShippingOrderConfig) (string, error) {
+	ctx = withOperation(ctx, "CreateShipOrder", config.MerchantTradeNo)
+	if err := config.Amount.validateRange(minShipAmount, maxShipAmount); err != nil {
+		return "", err
+	}
 	params := map[string]string{
-		"MerchantID":        e.MerchantID,
+		"MerchantID":        e.merchantID(config.MerchantID),
 		"MerchantTradeNo":   config.MerchantTradeNo,
 		"MerchantTradeDate": config.TradeDate.Format("2006/01/02 15:04:05"),
 		"LogisticsType":     "CVS",
 		"LogisticsSubType":  FormatStoreType(config.ShippingStoreType),
-		"GoodsAmount":       fmt.Sprintf("%.0f", config.Amount),
-		"CollectionAmount":  fmt.Sprintf("%.0f", config.Amount),
+		"GoodsAmount":       config.Amount.String(),
+		"CollectionAmount":  config.Amount.String(),
 		"IsCollection":      FormatNeedPayment(config.NeedPayment),
 		"GoodsName":         config.EntreeName,
 		"SenderName":        e.SenderName,
@@ -191,53 +370,68 @@ func (e *EcpayImpl) CreateShipOrder(config CreateShippingOrderConfig) (string, e
 		"ReceiverStoreID":   config.ReceiverStoreID,
 		"ClientReplyURL":    config.ClientReplyURL,
 		"ServerReplyURL":    e.ShipServerReplyURL,
-		"PlatformID":        "",
+		"PlatformID":        e.PlatformID,
 	}
 	checkMac := NewShipMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
 	params["CheckMacValue"] = checkMac
 
-	resp, err := e.client.R().SetFormData(params).
-		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		SetHeader("Cache-Control", "no-cache").
-		Post(fmt.Sprintf("%v/Express/Create", e.getShipURL()))
-	if err != nil {
-		return "", err
-	}
-	respString := resp.String()
-	param := strings.Split(respString, "|")
-	if param[0] != "1" {
-		if len(param) > 1 && param[1] == "廠商訂單編號重覆，請重新設定" {
-			return "", ErrDuplicateCreateShip
+	var resp string
+	err := e.Retry.retry(ctx, func(attempt int) error {
+		var err error
+		resp, err = e.createShipOrder(ctx, params)
+		if attempt > 1 && errors.Is(err, ErrDuplicateCreateShip) {
+			// An earlier attempt may have reached ECPay even though it
+			// failed here, answer with the shipment it created. The query
+			// is not retried on its own, this loop already retries.
+			values, _, queryErr := e.queryShip(ctx, config.MerchantID, config.MerchantTradeNo, false)
+			if queryErr != nil {
+				return queryErr
+			}
+			if matchErr := MatchShipOrder(config, values); matchErr != nil {
+				return matchErr
+			}
+			resp = shipQueryToCreateResponse(values)
+			return nil
 		}
-		return "", fmt.Errorf("response error status: %v, err: %v", param[0], param[1])
-	}
-	return param[1], nil
+		return err
+	})
+	return resp, err
+}
+
+func (e *EcpayImpl) createShipOrder(ctx context.Context, params map[string]string) (string, error) {
+	var resp string
+	err := e.post(ctx, e.getShipURL(), "Express/Create", params, func(respString string) (interface{}, error) {
+		param := strings.SplitN(respString, "|", 2)
+		if len(param) != 2 {
+			return nil, malformedResponse("Express/Create", respString, errors.New("missing RtnCode separator"))
+		}
+		if param[0] != "1" {
+			return nil, newLogisticsError("Express/Create", param[0], param[1], respString)
+		}
+		resp = param[1]
+		return e.ParseShipOrderResponse(resp)
+	})
+	return resp, err
 }
 
 type QueryShipConfig struct {
+	MerchantID      string
 	MerchantTradeNo string
 }
 
 func (e *EcpayImpl) QueryShip(config QueryShipConfig) (ShipOrderResponse, error) {
-	params := map[string]string{
-		"MerchantID":      e.MerchantID,
-		"MerchantTradeNo": config.MerchantTradeNo,
-		"TimeStamp":       fmt.Sprintf("%d", time.Now().Unix()),
-	}
-	checkMac := NewShipMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
-	params["CheckMacValue"] = checkMac
-	resp, err := e.client.R().SetFormData(params).
-		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		SetHeader("Cache-Control", "no-cache").
-		Post(fmt.Sprintf("%v/Helper/QueryLogisticsTradeInfo/V4", e.getShipURL()))
-	if err != nil {
-		return ShipOrderResponse{}, err
-	}
-	respString := resp.String()
-	fmt.Printf("resp: %v\n", respString)
+	return e.QueryShipContext(context.Background(), config)
+}
 
+func (e *EcpayImpl) QueryShipContext(ctx context.Context, config QueryShipConfig) (ShipOrderResponse, error) {
+	ctx = withOperation(ctx, "QueryShip", config.MerchantTradeNo)
+	_, response, err := e.queryShip(ctx, config.MerchantID, config.MerchantTradeNo, true)
+	return response, err
+}
+
+func shipOrderFromQuery(values url.Values) (ShipOrderResponse, error) {
 	var response ShipOrderResponse
-	values, err := url.ParseQuery(respString)
+	goodsAmount, err := parseAmountField(values, "GoodsAmount")
 	if err != nil {
 		return response, err
 	}
@@ -247,7 +441,7 @@ func (e *EcpayImpl) QueryShip(config QueryShipConfig) (ShipOrderResponse, error)
 	response.RtnMsg = values.Get("RtnMsg")
 	response.LogisticsID = values.Get("AllPayLogisticsID")
 	response.ShippingStoreType = TransferStoreType(values.Get("LogisticsType"))
-	response.GoodsAmount = values.Get("GoodsAmount")
+	response.GoodsAmount = goodsAmount
 	response.UpdateStatusDate = values.Get("TradeDate")
 
 	if response.ShippingStoreType == "711" {
@@ -256,7 +450,54 @@ func (e *EcpayImpl) QueryShip(config QueryShipConfig) (ShipOrderResponse, error)
 		response.CSVNo = values.Get("CVSPaymentNo")
 	}
 	response.Status = TransferStatus(response.ShippingStoreType, response.RtnCode)
-	return response, err
+	return response, nil
+}
+
+// queryShip follows the RetryPolicy when retry is set.
+func (e *EcpayImpl) queryShip(ctx context.Context, merchantID string, merchantTradeNo string, retry bool) (url.Values, ShipOrderResponse, error) {
+	params := map[string]string{
+		"MerchantID":      e.merchantID(merchantID),
+		"MerchantTradeNo": merchantTradeNo,
+		"TimeStamp":       fmt.Sprintf("%d", time.Now().Unix()),
+	}
+	e.setPlatformID(params)
+	checkMac := NewShipMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
+	params["CheckMacValue"] = checkMac
+	var values url.Values
+	var response ShipOrderResponse
+	post := e.post
+	if retry {
+		post = e.postIdempotent
+	}
+	err := post(ctx, e.getShipURL(), "Helper/QueryLogisticsTradeInfo/V4", params, func(respString string) (interface{}, error) {
+		if rtnCode, rtnMsg, found := strings.Cut(respString, "|"); found {
+			return nil, newLogisticsError("Helper/QueryLogisticsTradeInfo/V4", rtnCode, rtnMsg, respString)
+		}
+		var err error
+		values, err = url.ParseQuery(respString)
+		if err != nil {
+			return nil, malformedResponse("Helper/QueryLogisticsTradeInfo/V4", respString, err)
+		}
+		if values.Get("MerchantTradeNo") == "" {
+			return nil, malformedResponse("Helper/QueryLogisticsTradeInfo/V4", respString, errors.New("missing MerchantTradeNo"))
+		}
+		response, err = shipOrderFromQuery(values)
+		if err != nil {
+			return nil, malformedResponse("Helper/QueryLogisticsTradeInfo/V4", respString, err)
+		}
+		return response, nil
+	})
+	return values, response, err
+}
+
+// shipQueryToCreateResponse renames the fields of a logistics query so the
+// result can be read by ParseShipOrderResponse.
+func shipQueryToCreateResponse(values url.Values) string {
+	values.Set("RtnCode", values.Get("LogisticsStatus"))
+	values.Set("LogisticsSubType", values.Get("LogisticsType"))
+	values.Set("UpdateStatusDate", values.Get("TradeDate"))
+	values.Del("CheckMacValue")
+	return values.Encode()
 }
 
 func (e *EcpayImpl) ParseShipOrderResponse(resp string) (ShipOrderResponse, error) {
@@ -271,7 +512,10 @@ func (e *EcpayImpl) ParseShipOrderResponse(resp string) (ShipOrderResponse, erro
 	response.RtnMsg = values.Get("RtnMsg")
 	response.LogisticsID = values.Get("AllPayLogisticsID")
 	response.ShippingStoreType = TransferStoreType(values.Get("LogisticsSubType"))
-	response.GoodsAmount = values.Get("GoodsAmount")
+	response.GoodsAmount, err = parseAmountField(values, "GoodsAmount")
+	if err != nil {
+		return response, err
+	}
 	response.UpdateStatusDate = values.Get("UpdateStatusDate")
 
 	if response.ShippingStoreType == "711" {
@@ -284,6 +528,9 @@ func (e *EcpayImpl) ParseShipOrderResponse(resp string) (ShipOrderResponse, erro
 }
 
 func (e *EcpayImpl) getPaymentURL() string {
+	if e.paymentURL != "" {
+		return e.paymentURL
+	}
 	if e.IsProduction {
 		return paymentProductionURL
 	}
@@ -300,13 +547,16 @@ var supportPayments = []string{
 }
 
 func (e *EcpayImpl) CreatePaymentOrder(config PaymentConfig) (string, error) {
+	if err := config.Amount.Validate(); err != nil {
+		return "", err
+	}
 	params := map[string]string{
-		"MerchantID":        e.MerchantID,
+		"MerchantID":        e.merchantID(config.MerchantID),
 		"MerchantTradeNo":   config.MerchantTradeNo,
 		"MerchantTradeDate": config.TradeDate.Format("2006/01/02 15:04:05"),
 		"PaymentType":       "aio",
 		"ChoosePayment":     "ALL",
-		"TotalAmount":       fmt.Sprintf("%.0f", config.Amount),
+		"TotalAmount":       config.Amount.String(),
 		"TradeDesc":         config.EntreeName,
 		"ItemName":          config.EntreeName,
 		"ReturnURL":         e.PaymentServerReplyURL,
@@ -329,6 +579,7 @@ func (e *EcpayImpl) CreatePaymentOrder(config PaymentConfig) (string, error) {
 		}
 	}
 	params["IgnorePayment"] = strings.Join(ignorePayments, "#")
+	e.setPlatformID(params)
 
 	checkMac := NewPaymentMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
 	params["CheckMacValue"] = checkMac
@@ -369,12 +620,16 @@ type PaymentResponse struct {
 	RtnCode           string
 	RtnMsg            string
 	BankTransactionID string
-	Amount            float64
+	Amount            Money
 	TradeDate         string
 	PaymentType       string
-	PaymentFee        float64
+	PaymentFee        Money // rounded to the dollar
 	PaymentDate       time.Time
 	Simulation        bool
+	Status            PaymentStatus
+
+	// KeyID names the key pair that verified a notification.
+	KeyID string
 
 	// more info ..
 	WebATMAccBank  string
@@ -391,7 +646,7 @@ type PaymentResponse struct {
 }
 
 func (p *PaymentResponse) HasPaid() bool {
-	return p.RtnCode == "1"
+	return p.Status == PaymentStatusPaid
 }
 
 func (e *EcpayImpl) ParsePaymentResult(resp string) (*PaymentResponse, error) {
@@ -399,6 +654,10 @@ func (e *EcpayImpl) ParsePaymentResult(resp string) (*PaymentResponse, error) {
 	if err != nil {
 		return nil, err
 	}
+	return parsePaymentResult(values)
+}
+
+func parsePaymentResult(values url.Values) (*PaymentResponse, error) {
 	var respMap = make(map[string]string)
 	for key, value := range values {
 		if key == "CheckMacValue" {
@@ -407,8 +666,14 @@ func (e *EcpayImpl) ParsePaymentResult(resp string) (*PaymentResponse, error) {
 		respMap[key] = value[0]
 	}
 
-	amount, _ := strconv.ParseFloat(respMap["TradeAmt"], 64)
-	paymentFee, _ := strconv.ParseFloat(respMap["PaymentTypeChargeFee"], 64)
+	amount, err := parseAmountField(values, "TradeAmt")
+	if err != nil {
+		return nil, err
+	}
+	paymentFee, err := parseFeeField(values, "PaymentTypeChargeFee")
+	if err != nil {
+		return nil, err
+	}
 	simulation := respMap["SimulatePaid"] == "1"
 	paymentDate, _ := time.Parse("2006/01/02 15:04:05", respMap["PaymentDate"])
 
@@ -425,6 +690,7 @@ func (e *EcpayImpl) ParsePaymentResult(resp string) (*PaymentResponse, error) {
 	response.PaymentFee = paymentFee
 	response.PaymentDate = paymentDate
 	response.Simulation = simulation
+	response.Status = PaymentStatusFromNotify(response.RtnCode)
 	response.By = "notify"
 
 	if val, ok := respMap["WebATMAccBank"]; ok {
@@ -456,27 +722,54 @@ func (e *EcpayImpl) ParsePaymentResult(resp string) (*PaymentResponse, error) {
 }
 
 func (e *EcpayImpl) QueryPayment(config QueryConfig) (*PaymentResponse, error) {
+	return e.QueryPaymentContext(context.Background(), config)
+}
+
+func (e *EcpayImpl) QueryPaymentContext(ctx context.Context, config QueryConfig) (*PaymentResponse, error) {
+	ctx = withOperation(ctx, "QueryPayment", config.MerchantTradeNo)
 	params := map[string]string{
-		"MerchantID":      e.MerchantID,
+		"MerchantID":      e.merchantID(config.MerchantID),
 		"MerchantTradeNo": config.MerchantTradeNo,
 		"TimeStamp":       strconv.Itoa(int(time.Now().Unix())),
 	}
+	e.setPlatformID(params)
 	checkMac := NewPaymentMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
 	params["CheckMacValue"] = checkMac
 
-	resp, err := e.client.R().SetFormData(params).
-		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		SetHeader("Cache-Control", "no-cache").
-		Post(fmt.Sprintf("%s/Cashier/QueryTradeInfo/V5", e.getPaymentURL()))
+	var paymentResp *PaymentResponse
+	err := e.postIdempotent(ctx, e.getPaymentURL(), "Cashier/QueryTradeInfo/V5", params, func(respString string) (interface{}, error) {
+		retParams, err := url.ParseQuery(respString)
+		if err != nil {
+			return nil, malformedResponse("Cashier/QueryTradeInfo/V5", respString, err)
+		}
+		tradeStatus := retParams.Get("TradeStatus")
+		if tradeStatus == "" {
+			return nil, malformedResponse("Cashier/QueryTradeInfo/V5", respString, errors.New("missing TradeStatus"))
+		}
+		if !isQueryTradeStatus(tradeStatus) {
+			return nil, newPaymentError("Cashier/QueryTradeInfo/V5", tradeStatus, retParams.Get("RtnMsg"), respString)
+		}
+		paymentResp, err = paymentFromQuery(retParams)
+		if err != nil {
+			return nil, malformedResponse("Cashier/QueryTradeInfo/V5", respString, err)
+		}
+		return paymentResp, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	respString := resp.String()
-	retParams, err := url.ParseQuery(respString)
+	return paymentResp, nil
+}
+
+func paymentFromQuery(retParams url.Values) (*PaymentResponse, error) {
+	amount, err := parseAmountField(retParams, "TradeAmt")
+	if err != nil {
+		return nil, err
+	}
+	paymentFee, err := parseFeeField(retParams, "PaymentTypeChargeFee")
 	if err != nil {
 		return nil, err
 	}
-	amount, _ := strconv.ParseFloat(retParams.Get("TradeAmt"), 64)
 
 	var paymentResp *PaymentResponse = &PaymentResponse{}
 	paymentResp.MerchantID = retParams.Get("MerchantID")
@@ -486,23 +779,26 @@ func (e *EcpayImpl) QueryPayment(config QueryConfig) (*PaymentResponse, error) {
 	paymentResp.Amount = amount
 	paymentResp.TradeDate = retParams.Get("TradeDate")
 	paymentResp.PaymentType = retParams.Get("PaymentType")
-	paymentResp.PaymentFee, _ = strconv.ParseFloat(retParams.Get("PaymentTypeChargeFee"), 64)
+	paymentResp.PaymentFee = paymentFee
 	paymentResp.PaymentDate, _ = time.Parse("2006/01/02 15:04:05", retParams.Get("PaymentDate"))
 	paymentResp.RtnCode = retParams.Get("TradeStatus")
+	paymentResp.Status = PaymentStatusFromQuery(paymentResp.RtnCode, paymentResp.PaymentType)
 	paymentResp.By = "query"
 	return paymentResp, nil
 }
 
 type RefundConfig struct {
+	MerchantID        string
 	MerchantTradeNo   string
 	BankTransactionID string
 	RefundID          string
-	Amount            float64
+	Amount            Money
 }
 
 type RefundResponse struct {
 	RtnCode string
 	RtnMsg  string
+	Status  PaymentStatus
 }
 
 func (r *RefundResponse) IsSuccess() bool {
@@ -510,124 +806,120 @@ func (r *RefundResponse) IsSuccess() bool {
 }
 
 func (e *EcpayImpl) RefundPayment(config RefundConfig) (*RefundResponse, error) {
+	return e.RefundPaymentContext(context.Background(), config)
+}
+
+func (e *EcpayImpl) RefundPaymentContext(ctx context.Context, config RefundConfig) (*RefundResponse, error) {
+	ctx = withOperation(ctx, "RefundPayment", config.MerchantTradeNo)
+	if err := config.Amount.Validate(); err != nil {
+		return nil, err
+	}
 	params := map[string]string{
-		"MerchantID":      e.MerchantID,
+		"MerchantID":      e.merchantID(config.MerchantID),
 		"CreditRefundId":  config.RefundID,
-		"CreditAmount":    fmt.Sprintf("%.0f", config.Amount),
+		"CreditAmount":    config.Amount.String(),
 		"CreditCheckCode": e.CreditCheckKey,
 	}
+	e.setPlatformID(params)
 	checkMac := NewPaymentMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
 	params["CheckMacValue"] = checkMac
 
-	resp, err := e.client.R().SetFormData(params).
-		SetHeader("Content-Type", "application/x-www-form-urlencoded").
-		SetHeader("Cache-Control", "no-cache").
-		Post(fmt.Sprintf("%s/CreditDetail/QueryTrade/V2", e.getPaymentURL()))
-	if err != nil {
-		return nil, err
-	}
-	respString := resp.String()
-	var result map[string]interface{} = make(map[string]interface{})
-	err = json.Unmarshal([]byte(respString), &result)
+	var rtnValue map[string]interface{}
+	var closableAmount Money
+	err := e.postIdempotent(ctx, e.getPaymentURL(), "CreditDetail/QueryTrade/V2", params, func(respString string) (interface{}, error) {
+		var result map[string]interface{} = make(map[string]interface{})
+		err := json.Unmarshal([]byte(respString), &result)
+		if err != nil {
+			return nil, malformedResponse("CreditDetail/QueryTrade/V2", respString, err)
+		}
+
+		if rtnCode, ok := result["RtnCode"].(string); ok {
+			rtnMsg, _ := result["RtnMsg"].(string)
+			return nil, newPaymentError("CreditDetail/QueryTrade/V2", rtnCode, rtnMsg, respString)
+		}
+
+		var ok bool
+		rtnValue, ok = result["RtnValue"].(map[string]interface{})
+		if !ok {
+			return nil, malformedResponse("CreditDetail/QueryTrade/V2", respString, errors.New("missing RtnValue"))
+		}
+		if status, _ := rtnValue["status"].(string); status == "" {
+			return nil, malformedResponse("CreditDetail/QueryTrade/V2", respString, errors.New("missing status"))
+		}
+		clsamt, ok := rtnValue["clsamt"].(float64)
+		if !ok {
+			return nil, malformedResponse("CreditDetail/QueryTrade/V2", respString, errors.New("missing clsamt"))
+		}
+		closableAmount, err = MoneyFromFloat(clsamt)
+		if err != nil {
+			return nil, malformedResponse("CreditDetail/QueryTrade/V2", respString, err)
+		}
+		return rtnValue, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-
-	if _, ok := result["RtnCode"].(string); ok {
-		return nil, fmt.Errorf("query credit card payment error: %v", result["RtnMsg"].(string))
-	}
-
-	rtnValue := result["RtnValue"].(map[string]interface{})
 	rtnStatus := rtnValue["status"].(string)
 
 	params = map[string]string{
-		"MerchantID":      e.MerchantID,
+		"MerchantID":      e.merchantID(config.MerchantID),
 		"MerchantTradeNo": config.MerchantTradeNo,
 		"TradeNo":         config.BankTransactionID,
 		"Action":          "",
-		"TotalAmount":     fmt.Sprintf("%.0f", config.Amount),
+		"TotalAmount":     config.Amount.String(),
 	}
+	e.setPlatformID(params)
 
-	var res *RefundResponse = &RefundResponse{}
+	var res *RefundResponse
 	switch rtnStatus {
 	case "已授權":
-		params["Action"] = "N"
-		checkMac := NewPaymentMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
-		params["CheckMacValue"] = checkMac
-
-		resp, err := e.client.R().SetFormData(params).
-			SetHeader("Content-Type", "application/x-www-form-urlencoded").
-			SetHeader("Cache-Control", "no-cache").
-			Post(fmt.Sprintf("%s/CreditDetail/DoAction", e.getPaymentURL()))
-		if err != nil {
-			return nil, err
-		}
-		respString := resp.String()
-		retParams, err := url.ParseQuery(respString)
-		if err != nil {
-			return nil, err
-		}
-		res.RtnCode = retParams.Get("RtnCode")
-		res.RtnMsg = retParams.Get("RtnMsg")
+		res, err = e.creditDoAction(ctx, params, "N")
 	case "已關帳":
-		params["Action"] = "R"
-		checkMac := NewPaymentMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
-		params["CheckMacValue"] = checkMac
-
-		resp, err := e.client.R().SetFormData(params).
-			SetHeader("Content-Type", "application/x-www-form-urlencoded").
-			SetHeader("Cache-Control", "no-cache").
-			Post(fmt.Sprintf("%s/CreditDetail/DoAction", e.getPaymentURL()))
-		if err != nil {
-			return nil, err
+		res, err = e.creditDoAction(ctx, params, "R")
+	case "要關帳":
+		// Cancelling the close may fail when the trade was closed in the
+		// meantime, releasing the authorization is what matters.
+		var apiErr *APIError
+		res, err = e.creditDoAction(ctx, params, "E")
+		if err == nil || errors.As(err, &apiErr) {
+			res, err = e.creditDoAction(ctx, params, "N")
 		}
-		respString := resp.String()
-		retParams, err := url.ParseQuery(respString)
-		if err != nil {
-			return nil, err
-		}
-		res.RtnCode = retParams.Get("RtnCode")
-		res.RtnMsg = retParams.Get("RtnMsg")
 	default:
-		params["Action"] = "E"
-		checkMac := NewPaymentMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
-		params["CheckMacValue"] = checkMac
-
-		resp, err := e.client.R().SetFormData(params).
-			SetHeader("Content-Type", "application/x-www-form-urlencoded").
-			SetHeader("Cache-Control", "no-cache").
-			Post(fmt.Sprintf("%s/CreditDetail/DoAction", e.getPaymentURL()))
-		if err != nil {
-			return nil, err
-		}
-		respString := resp.String()
+		return nil, fmt.Errorf("%w: credit trade %v is %q", ErrRefundNotAllowed, config.MerchantTradeNo, rtnStatus)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Status = PaymentStatusFromRefund(params["Action"], config.Amount, closableAmount)
+	return res, nil
+}
+
+func (e *EcpayImpl) creditDoAction(ctx context.Context, params map[string]string, action string) (*RefundResponse, error) {
+	delete(params, "CheckMacValue")
+	params["Action"] = action
+	checkMac := NewPaymentMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
+	params["CheckMacValue"] = checkMac
+
+	var res *RefundResponse
+	err := e.post(ctx, e.getPaymentURL(), "CreditDetail/DoAction", params, func(respString string) (interface{}, error) {
 		retParams, err := url.ParseQuery(respString)
 		if err != nil {
-			return nil, err
+			return nil, malformedResponse("CreditDetail/DoAction", respString, err)
 		}
-		res.RtnCode = retParams.Get("RtnCode")
-		res.RtnMsg = retParams.Get("RtnMsg")
-		params["Action"] = "N"
-		checkMac = NewPaymentMacValue(e.EcpayConfig).GenerateCheckMacValue(params)
-		params["CheckMacValue"] = checkMac
-
-		resp, err = e.client.R().SetFormData(params).
-			SetHeader("Content-Type", "application/x-www-form-urlencoded").
-			SetHeader("Cache-Control", "no-cache").
-			Post(fmt.Sprintf("%s/CreditDetail/DoAction", e.getPaymentURL()))
-		if err != nil {
-			return nil, err
+		if retParams.Get("RtnCode") == "" {
+			return nil, malformedResponse("CreditDetail/DoAction", respString, errors.New("missing RtnCode"))
 		}
-		respString = resp.String()
-		retParams, err = url.ParseQuery(respString)
-		if err != nil {
-			return nil, err
+		res = &RefundResponse{
+			RtnCode: retParams.Get("RtnCode"),
+			RtnMsg:  retParams.Get("RtnMsg"),
 		}
-		res.RtnCode = retParams.Get("RtnCode")
-		res.RtnMsg = retParams.Get("RtnMsg")
-	}
-	if !res.IsSuccess() {
-		return nil, fmt.Errorf("close credit card payment error: %v", res.RtnMsg)
+		if !res.IsSuccess() {
+			return res, newPaymentError("CreditDetail/DoAction", res.RtnCode, res.RtnMsg, respString)
+		}
+		return res, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return res, nil
 }
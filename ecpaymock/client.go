@@ -0,0 +1,337 @@
+// Package ecpaymock provides an in-memory fake of the ecpay.EcpayContext interface
+// for unit tests. It keeps shipments and payments per MerchantTradeNo,
+// records every call and can be told to fail specific trades.
+package ecpaymock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/img21326/ecpay"
+)
+
+const (
+	MethodChooseShipStore    = "ChooseShipStore"
+	MethodCreateShipOrder    = "CreateShipOrder"
+	MethodQueryShip          = "QueryShip"
+	MethodCreatePaymentOrder = "CreatePaymentOrder"
+	MethodQueryPayment       = "QueryPayment"
+	MethodRefundPayment      = "RefundPayment"
+)
+
+type Call struct {
+	Method          string
+	MerchantTradeNo string
+	// Config is the config struct the method was called with.
+	Config interface{}
+}
+
+type Client struct {
+	parser ecpay.Ecpay
+
+	mu        sync.Mutex
+	seq       int
+	calls     []Call
+	errs      map[string]error
+	shipments map[string]ecpay.ShipOrderResponse
+	// shipQueries holds the fields a logistics query would return for
+	// shipments created through the mock.
+	shipQueries map[string]url.Values
+	payments    map[string]ecpay.PaymentResponse
+	refunds     map[string]ecpay.RefundResponse
+	refunded    map[string]ecpay.Money
+}
+
+var _ ecpay.EcpayContext = (*Client)(nil)
+
+func New() *Client {
+	return &Client{
+		parser:      ecpay.NewEcpay(ecpay.EcpayConfig{}),
+		errs:        make(map[string]error),
+		shipments:   make(map[string]ecpay.ShipOrderResponse),
+		shipQueries: make(map[string]url.Values),
+		payments:    make(map[string]ecpay.PaymentResponse),
+		refunds:     make(map[string]ecpay.RefundResponse),
+		refunded:    make(map[string]ecpay.Money),
+	}
+}
+
+func errKey(method string, merchantTradeNo string) string {
+	return method + "|" + merchantTradeNo
+}
+
+// SetError makes method fail with err for merchantTradeNo. An empty method
+// applies to every method.
+func (c *Client) SetError(method string, merchantTradeNo string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs[errKey(method, merchantTradeNo)] = err
+}
+
+// SetShipOrder sets the shipment returned by QueryShip. A shipment that
+// exists makes CreateShipOrder fail with ecpay.ErrDuplicateCreateShip.
+func (c *Client) SetShipOrder(resp ecpay.ShipOrderResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shipments[resp.MerchantTradeNo] = resp
+	delete(c.shipQueries, resp.MerchantTradeNo)
+}
+
+// SetPayment sets the payment returned by QueryPayment and refunded by
+// RefundPayment.
+func (c *Client) SetPayment(resp ecpay.PaymentResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payments[resp.TradeNo] = resp
+}
+
+// SetRefund sets the result of RefundPayment for merchantTradeNo instead of
+// deriving it from the stored payment.
+func (c *Client) SetRefund(merchantTradeNo string, resp ecpay.RefundResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refunds[merchantTradeNo] = resp
+}
+
+// Pay marks a payment created with CreatePaymentOrder as paid.
+func (c *Client) Pay(merchantTradeNo string, paymentType string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.payments[merchantTradeNo]
+	if !ok {
+		return fmt.Errorf("ecpaymock: unknown payment %v", merchantTradeNo)
+	}
+	p.RtnCode = "1"
+	p.Status = ecpay.PaymentStatusPaid
+	p.PaymentType = paymentType
+	p.PaymentDate = time.Now()
+	c.payments[merchantTradeNo] = p
+	return nil
+}
+
+func (c *Client) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call(nil), c.calls...)
+}
+
+func (c *Client) CallsTo(method string) []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var calls []Call
+	for _, call := range c.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (c *Client) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = nil
+	c.errs = make(map[string]error)
+	c.shipments = make(map[string]ecpay.ShipOrderResponse)
+	c.shipQueries = make(map[string]url.Values)
+	c.payments = make(map[string]ecpay.PaymentResponse)
+	c.refunds = make(map[string]ecpay.RefundResponse)
+	c.refunded = make(map[string]ecpay.Money)
+}
+
+// record stores the call and returns the configured error, if any. The
+// caller must hold c.mu.
+func (c *Client) record(ctx context.Context, method string, merchantTradeNo string, config interface{}) error {
+	c.calls = append(c.calls, Call{Method: method, MerchantTradeNo: merchantTradeNo, Config: config})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err, ok := c.errs[errKey(method, merchantTradeNo)]; ok {
+		return err
+	}
+	if err, ok := c.errs[errKey("", merchantTradeNo)]; ok {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) nextID() string {
+	c.seq++
+	return fmt.Sprintf("%d", 1000000+c.seq)
+}
+
+func (c *Client) ChooseShipStore(config ecpay.ChooseShipStoreConfig) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.record(context.Background(), MethodChooseShipStore, config.MerchantTradeNo, config); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(`<form id="myForm" method="POST" action="ecpaymock/Express/map"><input type="hidden" name="MerchantTradeNo" value="%s"></form>`, config.MerchantTradeNo), nil
+}
+
+func (c *Client) CreateShipOrder(config ecpay.CreateShippingOrderConfig) (string, error) {
+	return c.CreateShipOrderContext(context.Background(), config)
+}
+
+func (c *Client) CreateShipOrderContext(ctx context.Context, config ecpay.CreateShippingOrderConfig) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.record(ctx, MethodCreateShipOrder, config.MerchantTradeNo, config); err != nil {
+		return "", err
+	}
+	if _, ok := c.shipments[config.MerchantTradeNo]; ok {
+		return "", ecpay.ErrDuplicateCreateShip
+	}
+	values := url.Values{}
+	values.Set("MerchantTradeNo", config.MerchantTradeNo)
+	values.Set("RtnCode", "300")
+	values.Set("RtnMsg", "訂單處理中(已收到訂單資料)")
+	values.Set("AllPayLogisticsID", c.nextID())
+	values.Set("LogisticsSubType", ecpay.FormatStoreType(config.ShippingStoreType))
+	values.Set("GoodsAmount", config.Amount.String())
+	values.Set("UpdateStatusDate", time.Now().Format("2006/01/02 15:04:05"))
+	values.Set("CVSPaymentNo", c.nextID())
+
+	resp, _ := c.parser.ParseShipOrderResponse(values.Encode())
+	c.shipments[config.MerchantTradeNo] = resp
+	c.shipQueries[config.MerchantTradeNo] = url.Values{
+		"LogisticsType":     {"CVS_" + ecpay.FormatStoreType(config.ShippingStoreType)},
+		"GoodsAmount":       {config.Amount.String()},
+		"GoodsName":         {config.EntreeName},
+		"ReceiverName":      {config.ReceiverName},
+		"ReceiverCellPhone": {config.ReceiverPhone},
+		"ReceiverStoreID":   {config.ReceiverStoreID},
+	}
+	return values.Encode(), nil
+}
+
+func (c *Client) CreateShipOrderIdempotent(config ecpay.CreateShippingOrderConfig) (ecpay.ShipOrderResponse, error) {
+	return c.CreateShipOrderIdempotentContext(context.Background(), config)
+}
+
+// CreateShipOrderIdempotentContext returns the stored shipment for a
+// duplicate MerchantTradeNo when ecpay.MatchShipOrder accepts it. Shipments
+// set with SetShipOrder only have their store type and amount compared.
+func (c *Client) CreateShipOrderIdempotentContext(ctx context.Context, config ecpay.CreateShippingOrderConfig) (ecpay.ShipOrderResponse, error) {
+	resp, err := c.CreateShipOrderContext(ctx, config)
+	if err == nil {
+		return c.parser.ParseShipOrderResponse(resp)
+	}
+	if !errors.Is(err, ecpay.ErrDuplicateCreateShip) {
+		return ecpay.ShipOrderResponse{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	existing := c.shipments[config.MerchantTradeNo]
+	query, ok := c.shipQueries[config.MerchantTradeNo]
+	if !ok {
+		query = url.Values{
+			"LogisticsType": {"CVS_" + ecpay.FormatStoreType(existing.ShippingStoreType)},
+			"GoodsAmount":   {existing.GoodsAmount.String()},
+		}
+	}
+	if err := ecpay.MatchShipOrder(config, query); err != nil {
+		return ecpay.ShipOrderResponse{}, err
+	}
+	return existing, nil
+}
+
+func (c *Client) QueryShip(config ecpay.QueryShipConfig) (ecpay.ShipOrderResponse, error) {
+	return c.QueryShipContext(context.Background(), config)
+}
+
+func (c *Client) QueryShipContext(ctx context.Context, config ecpay.QueryShipConfig) (ecpay.ShipOrderResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.record(ctx, MethodQueryShip, config.MerchantTradeNo, config); err != nil {
+		return ecpay.ShipOrderResponse{}, err
+	}
+	resp, ok := c.shipments[config.MerchantTradeNo]
+	if !ok {
+		return ecpay.ShipOrderResponse{}, fmt.Errorf("ecpaymock: unknown shipment %v", config.MerchantTradeNo)
+	}
+	return resp, nil
+}
+
+func (c *Client) ParseShipOrderResponse(resp string) (ecpay.ShipOrderResponse, error) {
+	return c.parser.ParseShipOrderResponse(resp)
+}
+
+func (c *Client) CreatePaymentOrder(config ecpay.PaymentConfig) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.record(context.Background(), MethodCreatePaymentOrder, config.MerchantTradeNo, config); err != nil {
+		return "", err
+	}
+	if err := config.Amount.Validate(); err != nil {
+		return "", err
+	}
+	c.payments[config.MerchantTradeNo] = ecpay.PaymentResponse{
+		TradeNo:           config.MerchantTradeNo,
+		StoreID:           config.StoreID,
+		BankTransactionID: c.nextID(),
+		RefundID:          c.nextID(),
+		Amount:            config.Amount,
+		TradeDate:         config.TradeDate.Format("2006/01/02 15:04:05"),
+		RtnCode:           "0",
+		Status:            ecpay.PaymentStatusPending,
+		By:                "query",
+	}
+	return fmt.Sprintf(`<form id="myForm" method="POST" action="ecpaymock/Cashier/AioCheckOut/V5"><input type="hidden" name="MerchantTradeNo" value="%s"></form>`, config.MerchantTradeNo), nil
+}
+
+func (c *Client) ParsePaymentResult(resp string) (*ecpay.PaymentResponse, error) {
+	return c.parser.ParsePaymentResult(resp)
+}
+
+func (c *Client) QueryPayment(config ecpay.QueryConfig) (*ecpay.PaymentResponse, error) {
+	return c.QueryPaymentContext(context.Background(), config)
+}
+
+func (c *Client) QueryPaymentContext(ctx context.Context, config ecpay.QueryConfig) (*ecpay.PaymentResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.record(ctx, MethodQueryPayment, config.MerchantTradeNo, config); err != nil {
+		return nil, err
+	}
+	resp, ok := c.payments[config.MerchantTradeNo]
+	if !ok {
+		return nil, fmt.Errorf("ecpaymock: unknown payment %v", config.MerchantTradeNo)
+	}
+	return &resp, nil
+}
+
+func (c *Client) RefundPayment(config ecpay.RefundConfig) (*ecpay.RefundResponse, error) {
+	return c.RefundPaymentContext(context.Background(), config)
+}
+
+func (c *Client) RefundPaymentContext(ctx context.Context, config ecpay.RefundConfig) (*ecpay.RefundResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.record(ctx, MethodRefundPayment, config.MerchantTradeNo, config); err != nil {
+		return nil, err
+	}
+	if resp, ok := c.refunds[config.MerchantTradeNo]; ok {
+		return &resp, nil
+	}
+	if err := config.Amount.Validate(); err != nil {
+		return nil, err
+	}
+	p, ok := c.payments[config.MerchantTradeNo]
+	if !ok || !p.Status.CanTransitionTo(ecpay.PaymentStatusRefunded) || p.Status == ecpay.PaymentStatusRefunded {
+		return nil, fmt.Errorf("close credit card payment error: trade %v cannot be refunded", config.MerchantTradeNo)
+	}
+	closable := p.Amount - c.refunded[config.MerchantTradeNo]
+	if config.Amount > closable {
+		return nil, fmt.Errorf("close credit card payment error: refund %v exceeds %v left on trade %v", config.Amount, closable, config.MerchantTradeNo)
+	}
+	status := ecpay.PaymentStatusFromRefund("R", config.Amount, closable)
+	c.refunded[config.MerchantTradeNo] += config.Amount
+	p.Status = status
+	c.payments[config.MerchantTradeNo] = p
+	return &ecpay.RefundResponse{RtnCode: "1", RtnMsg: "成功.", Status: status}, nil
+}
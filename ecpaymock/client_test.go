@@ -0,0 +1,71 @@
+package ecpaymock_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaymock"
+)
+
+func shipOrderConfig() ecpay.CreateShippingOrderConfig {
+	return ecpay.CreateShippingOrderConfig{
+		MerchantTradeNo:   "S1",
+		TradeDate:         time.Now(),
+		ShippingStoreType: "711",
+		Amount:            100,
+		EntreeName:        "goods",
+		ReceiverName:      "receiver",
+		ReceiverPhone:     "0911111111",
+		ReceiverStoreID:   "131386",
+	}
+}
+
+func TestDuplicateCreateShipOrder(t *testing.T) {
+	c := ecpaymock.New()
+	if _, err := c.CreateShipOrder(shipOrderConfig()); err != nil {
+		t.Fatalf("CreateShipOrder: %v", err)
+	}
+	if _, err := c.CreateShipOrder(shipOrderConfig()); !errors.Is(err, ecpay.ErrDuplicateCreateShip) {
+		t.Errorf("duplicate CreateShipOrder error = %v, want ErrDuplicateCreateShip", err)
+	}
+	if !errors.Is(ecpay.ErrDuplicateCreateShip, ecpay.ErrDuplicateTradeNo) {
+		t.Error("ErrDuplicateCreateShip does not match ErrDuplicateTradeNo")
+	}
+	if calls := c.CallsTo(ecpaymock.MethodCreateShipOrder); len(calls) != 2 {
+		t.Errorf("%d CreateShipOrder calls recorded, want 2", len(calls))
+	}
+}
+
+func TestSetError(t *testing.T) {
+	c := ecpaymock.New()
+	failure := errors.New("maintenance")
+	c.SetError(ecpaymock.MethodCreateShipOrder, "S1", failure)
+	if _, err := c.CreateShipOrder(shipOrderConfig()); !errors.Is(err, failure) {
+		t.Errorf("CreateShipOrder error = %v, want %v", err, failure)
+	}
+	if _, err := c.QueryShip(ecpay.QueryShipConfig{MerchantTradeNo: "S1"}); err == nil || errors.Is(err, failure) {
+		t.Errorf("QueryShip error = %v, want an unknown shipment", err)
+	}
+}
+
+func TestCreateShipOrderIdempotent(t *testing.T) {
+	c := ecpaymock.New()
+	first, err := c.CreateShipOrderIdempotent(shipOrderConfig())
+	if err != nil {
+		t.Fatalf("CreateShipOrderIdempotent: %v", err)
+	}
+	again, err := c.CreateShipOrderIdempotent(shipOrderConfig())
+	if err != nil || again.LogisticsID != first.LogisticsID {
+		t.Errorf("repeated create = %v, %v, want shipment %v", again.LogisticsID, err, first.LogisticsID)
+	}
+
+	changed := shipOrderConfig()
+	changed.ReceiverStoreID = "991182"
+	_, err = c.CreateShipOrderIdempotent(changed)
+	var mismatch *ecpay.ShipOrderMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Field != "ReceiverStoreID" {
+		t.Errorf("create with another store error = %v, want a ReceiverStoreID mismatch", err)
+	}
+}
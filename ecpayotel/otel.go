@@ -0,0 +1,163 @@
+// Package ecpayotel traces ECPay calls and callbacks with OpenTelemetry.
+//
+//	client := ecpay.NewEcpay(config, ecpay.WithMiddleware(ecpayotel.Middleware()))
+//	handler := ecpay.NewPaymentNotifyHandler(config, handle,
+//		ecpay.WithNotifyMiddleware(ecpayotel.NotifyMiddleware()))
+package ecpayotel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/img21326/ecpay"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/img21326/ecpay/ecpayotel"
+
+const (
+	OperationKey       = attribute.Key("ecpay.operation")
+	EndpointKey        = attribute.Key("ecpay.endpoint")
+	MerchantTradeNoKey = attribute.Key("ecpay.merchant_trade_no")
+	ActionKey          = attribute.Key("ecpay.action")
+	RtnCodeKey         = attribute.Key("ecpay.rtn_code")
+	OutcomeKey         = attribute.Key("ecpay.outcome")
+	NotificationKey    = attribute.Key("ecpay.notification")
+	NotifyOutcomeKey   = attribute.Key("ecpay.notify_outcome")
+	KeyIDKey           = attribute.Key("ecpay.key_id")
+	StatusCodeKey      = attribute.Key("http.status_code")
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+type Option func(*config)
+
+type config struct {
+	provider    trace.TracerProvider
+	propagators propagation.TextMapPropagator
+}
+
+// WithTracerProvider defaults to the global provider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// WithPropagators sets the propagators injecting trace headers into ECPay
+// requests, defaults to the global ones.
+func WithPropagators(propagators propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagators = propagators
+	}
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		provider:    otel.GetTracerProvider(),
+		propagators: otel.GetTextMapPropagator(),
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// Middleware starts a client span per ECPay request, so a refund shows its
+// CreditDetail/QueryTrade/V2 lookup and every CreditDetail/DoAction step,
+// and each retry, separately.
+func Middleware(opts ...Option) ecpay.Middleware {
+	c := newConfig(opts)
+	tracer := c.provider.Tracer(instrumentationName)
+	return func(next ecpay.Invoker) ecpay.Invoker {
+		return func(ctx context.Context, call *ecpay.Call) error {
+			attrs := []attribute.KeyValue{
+				OperationKey.String(call.Operation),
+				EndpointKey.String(call.Endpoint),
+				MerchantTradeNoKey.String(call.MerchantTradeNo),
+			}
+			if action := call.Params["Action"]; action != "" {
+				attrs = append(attrs, ActionKey.String(action))
+			}
+			ctx, span := tracer.Start(ctx, spanName(call.Operation, call.Endpoint),
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(attrs...))
+			defer span.End()
+			if call.Header == nil {
+				call.Header = http.Header{}
+			}
+			c.propagators.Inject(ctx, propagation.HeaderCarrier(call.Header))
+
+			err := next(ctx, call)
+			if call.StatusCode != 0 {
+				span.SetAttributes(StatusCodeKey.Int(call.StatusCode))
+			}
+			if call.RtnCode != "" {
+				span.SetAttributes(RtnCodeKey.String(call.RtnCode))
+			}
+			end(span, err)
+			return err
+		}
+	}
+}
+
+// NotifyMiddleware starts a server span per ECPay callback.
+func NotifyMiddleware(opts ...Option) ecpay.NotifyMiddleware {
+	c := newConfig(opts)
+	tracer := c.provider.Tracer(instrumentationName)
+	return func(next ecpay.NotifyInvoker) ecpay.NotifyInvoker {
+		return func(ctx context.Context, n *ecpay.Notification) error {
+			ctx, span := tracer.Start(ctx, spanName("Notify", string(n.Type)),
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					NotificationKey.String(string(n.Type)),
+					MerchantTradeNoKey.String(n.MerchantTradeNo),
+					RtnCodeKey.String(n.RtnCode),
+				))
+			defer span.End()
+
+			err := next(ctx, n)
+			span.SetAttributes(NotifyOutcomeKey.String(string(n.Outcome)))
+			if n.KeyID != "" {
+				span.SetAttributes(KeyIDKey.String(n.KeyID))
+			}
+			if n.Outcome == ecpay.NotifyRejected {
+				span.SetAttributes(OutcomeKey.String(OutcomeSuccess))
+				span.RecordError(err)
+				return err
+			}
+			end(span, err)
+			return err
+		}
+	}
+}
+
+func spanName(operation string, endpoint string) string {
+	if operation == "" {
+		return "ecpay " + endpoint
+	}
+	return "ecpay " + operation + " " + endpoint
+}
+
+func end(span trace.Span, err error) {
+	if err == nil {
+		span.SetAttributes(OutcomeKey.String(OutcomeSuccess))
+		return
+	}
+	span.SetAttributes(OutcomeKey.String(OutcomeError))
+	span.RecordError(err)
+	var apiErr *ecpay.APIError
+	if errors.As(err, &apiErr) {
+		span.SetStatus(codes.Error, apiErr.RtnMsg)
+		return
+	}
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,92 @@
+package ecpayotel_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpayotel"
+	"github.com/img21326/ecpay/ecpaytest"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]string {
+	attrs := make(map[attribute.Key]string)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value.Emit()
+	}
+	return attrs
+}
+
+func TestRefundSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer provider.Shutdown(context.Background())
+
+	config := ecpay.EcpayConfig{
+		MerchantID:     "3002607",
+		HashKey:        "pwFHCqoQZGmho4w6",
+		HashIV:         "EkRm7iFT261dpevs",
+		CreditCheckKey: "creditcheck",
+	}
+	notified := make(chan *ecpay.PaymentResponse, 1)
+	notifySrv := httptest.NewServer(ecpay.NewPaymentNotifyHandler(config, func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+		notified <- resp
+		return nil
+	}))
+	defer notifySrv.Close()
+	config.PaymentServerReplyURL = notifySrv.URL
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+	ctx := context.Background()
+
+	ec := srv.Client()
+	html, err := ec.CreatePaymentOrder(ecpay.PaymentConfig{MerchantTradeNo: "T1", TradeDate: time.Now(), Amount: 100, EntreeName: "goods", SupportPayments: []string{"Credit"}})
+	if err != nil {
+		t.Fatalf("CreatePaymentOrder: %v", err)
+	}
+	if err := srv.SubmitForm(ctx, html); err != nil {
+		t.Fatalf("SubmitForm: %v", err)
+	}
+	if err := srv.Pay(ctx, "T1", "Credit_CreditCard"); err != nil {
+		t.Fatalf("Pay: %v", err)
+	}
+	paid := <-notified
+	// A trade waiting to be closed is refunded by cancelling the close (E)
+	// and releasing the authorization (N).
+	if err := srv.SetCreditStatus("T1", ecpaytest.CreditClosing); err != nil {
+		t.Fatalf("SetCreditStatus: %v", err)
+	}
+
+	traced := srv.Client(ecpay.WithMiddleware(ecpayotel.Middleware(ecpayotel.WithTracerProvider(provider))))
+	resp, err := traced.RefundPayment(ecpay.RefundConfig{MerchantTradeNo: "T1", BankTransactionID: paid.BankTransactionID, RefundID: paid.RefundID, Amount: 100})
+	if err != nil {
+		t.Fatalf("RefundPayment: %v", err)
+	}
+	if resp.Status != ecpay.PaymentStatusCancelled {
+		t.Errorf("Status = %v, want %v", resp.Status, ecpay.PaymentStatusCancelled)
+	}
+
+	spans := exporter.GetSpans().Snapshots()
+	var actions []string
+	for _, span := range spans {
+		attrs := attributes(span)
+		if attrs[ecpayotel.OperationKey] != "RefundPayment" {
+			t.Errorf("span %v operation = %q, want RefundPayment", span.Name(), attrs[ecpayotel.OperationKey])
+		}
+		if attrs[ecpayotel.EndpointKey] != "CreditDetail/DoAction" {
+			continue
+		}
+		actions = append(actions, attrs[ecpayotel.ActionKey])
+		if attrs[ecpayotel.RtnCodeKey] != "1" {
+			t.Errorf("DoAction %v rtn_code = %q, want 1", attrs[ecpayotel.ActionKey], attrs[ecpayotel.RtnCodeKey])
+		}
+	}
+	if len(spans) != 3 || len(actions) != 2 || actions[0] != "E" || actions[1] != "N" {
+		t.Errorf("%d spans with DoAction steps %v, want the query and steps [E N]", len(spans), actions)
+	}
+}
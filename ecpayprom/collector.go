@@ -0,0 +1,183 @@
+// Package ecpayprom exports Prometheus metrics for ECPay calls and
+// callbacks.
+//
+//	collector := ecpayprom.NewCollector()
+//	prometheus.MustRegister(collector)
+//	client := ecpay.NewEcpay(config, ecpay.WithMiddleware(collector.Middleware()))
+//	handler := ecpay.NewPaymentNotifyHandler(config, handle,
+//		ecpay.WithNotifyMiddleware(collector.NotifyMiddleware()))
+package ecpayprom
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/img21326/ecpay"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcome label values of ecpay_requests_total.
+const (
+	OutcomeSuccess            = "success"
+	OutcomeAPIError           = "api_error"
+	OutcomeUnexpectedResponse = "unexpected_response"
+	OutcomeNetworkError       = "network_error"
+)
+
+var defaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type Option func(*options)
+
+type options struct {
+	namespace string
+	buckets   []float64
+}
+
+// WithNamespace prefixes metric names, defaults to "ecpay".
+func WithNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithBuckets sets the latency histogram buckets in seconds.
+func WithBuckets(buckets []float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// Collector is a prometheus.Collector fed by its client and notify
+// middleware. One Collector can be shared by several clients and handlers.
+type Collector struct {
+	requests    *prometheus.CounterVec
+	latency     *prometheus.HistogramVec
+	errors      *prometheus.CounterVec
+	callbacks   *prometheus.CounterVec
+	macFailures *prometheus.CounterVec
+	shipments   *prometheus.CounterVec
+}
+
+func NewCollector(opts ...Option) *Collector {
+	o := options{namespace: "ecpay", buckets: defaultBuckets}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Collector{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "requests_total",
+			Help:      "Requests sent to ECPay by endpoint and outcome.",
+		}, []string{"endpoint", "outcome"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: o.namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Latency of requests sent to ECPay by endpoint.",
+			Buckets:   o.buckets,
+		}, []string{"endpoint"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "errors_total",
+			Help:      "Failure RtnCodes returned by ECPay by endpoint.",
+		}, []string{"endpoint", "rtn_code"}),
+		callbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "callbacks_total",
+			Help:      "Callbacks received from ECPay by type and outcome.",
+		}, []string{"type", "outcome"}),
+		macFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "mac_verification_failures_total",
+			Help:      "CheckMacValue failures, on callbacks we received or requests ECPay rejected.",
+		}, []string{"source"}),
+		shipments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: o.namespace,
+			Name:      "shipments_total",
+			Help:      "Shipments created and status changes reported by logistics callbacks by normalized status.",
+		}, []string{"status"}),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.requests.Describe(ch)
+	c.latency.Describe(ch)
+	c.errors.Describe(ch)
+	c.callbacks.Describe(ch)
+	c.macFailures.Describe(ch)
+	c.shipments.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.requests.Collect(ch)
+	c.latency.Collect(ch)
+	c.errors.Collect(ch)
+	c.callbacks.Collect(ch)
+	c.macFailures.Collect(ch)
+	c.shipments.Collect(ch)
+}
+
+// Middleware records every request, retries included.
+func (c *Collector) Middleware() ecpay.Middleware {
+	return func(next ecpay.Invoker) ecpay.Invoker {
+		return func(ctx context.Context, call *ecpay.Call) error {
+			start := time.Now()
+			err := next(ctx, call)
+			c.latency.WithLabelValues(call.Endpoint).Observe(time.Since(start).Seconds())
+			c.requests.WithLabelValues(call.Endpoint, outcome(err)).Inc()
+			var apiErr *ecpay.APIError
+			if errors.As(err, &apiErr) {
+				c.errors.WithLabelValues(call.Endpoint, apiErr.RtnCode).Inc()
+			}
+			if errors.Is(err, ecpay.ErrInvalidCheckMacValue) {
+				c.macFailures.WithLabelValues("request").Inc()
+			}
+			// Queries are not counted, polling would count the same
+			// shipment on every run.
+			if shipment, ok := call.Result.(ecpay.ShipOrderResponse); ok && err == nil && call.Endpoint == "Express/Create" {
+				c.ObserveShipment(shipment)
+			}
+			return err
+		}
+	}
+}
+
+// NotifyMiddleware records every callback by its outcome.
+func (c *Collector) NotifyMiddleware() ecpay.NotifyMiddleware {
+	return func(next ecpay.NotifyInvoker) ecpay.NotifyInvoker {
+		return func(ctx context.Context, n *ecpay.Notification) error {
+			err := next(ctx, n)
+			c.callbacks.WithLabelValues(string(n.Type), string(n.Outcome)).Inc()
+			if errors.Is(err, ecpay.ErrInvalidCheckMacValue) {
+				c.macFailures.WithLabelValues("callback").Inc()
+			}
+			return err
+		}
+	}
+}
+
+// ObserveShipment counts a shipment status change, e.g. one parsed from a
+// logistics status callback with ParseShipOrderResponse. Shipments created
+// through Middleware are counted already; do not pass query results, they
+// repeat the same status.
+func (c *Collector) ObserveShipment(shipment ecpay.ShipOrderResponse) {
+	status := shipment.Status
+	if status == "" {
+		status = "UNKNOWN"
+	}
+	c.shipments.WithLabelValues(status).Inc()
+}
+
+func outcome(err error) string {
+	var apiErr *ecpay.APIError
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.As(err, &apiErr):
+		return OutcomeAPIError
+	case errors.Is(err, ecpay.ErrUnexpectedResponse):
+		return OutcomeUnexpectedResponse
+	default:
+		return OutcomeNetworkError
+	}
+}
@@ -0,0 +1,84 @@
+package ecpayprom_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpayprom"
+	"github.com/img21326/ecpay/ecpaytest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// counter sums the samples of name whose labels include labels.
+func counter(t *testing.T, registry *prometheus.Registry, name string, labels map[string]string) float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	var sum float64
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, metric := range family.GetMetric() {
+			values := make(map[string]string)
+			for _, pair := range metric.GetLabel() {
+				values[pair.GetName()] = pair.GetValue()
+			}
+			for key, value := range labels {
+				if values[key] != value {
+					continue metrics
+				}
+			}
+			sum += metric.GetCounter().GetValue()
+		}
+	}
+	return sum
+}
+
+func TestCollector(t *testing.T) {
+	config := ecpay.EcpayConfig{
+		MerchantID:  "2000933",
+		HashKey:     "XBERn1YOvpM9nfZc",
+		HashIV:      "h1ONHk4P4yqbl5LK",
+		SenderName:  "sender",
+		SenderPhone: "0912345678",
+	}
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+	collector := ecpayprom.NewCollector()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	ec := srv.Client(ecpay.WithMiddleware(collector.Middleware()))
+
+	_, err := ec.CreateShipOrder(ecpay.CreateShippingOrderConfig{
+		MerchantTradeNo:   "S1",
+		TradeDate:         time.Now(),
+		ShippingStoreType: "711",
+		Amount:            100,
+		EntreeName:        "goods",
+		ReceiverName:      "receiver",
+		ReceiverPhone:     "0911111111",
+		ReceiverStoreID:   "131386",
+	})
+	if err != nil {
+		t.Fatalf("CreateShipOrder: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		ecpay.QueryShips(context.Background(), ec, []string{"S1", "S2"}, ecpay.BatchOptions{})
+	}
+
+	if got := counter(t, registry, "ecpay_shipments_total", nil); got != 1 {
+		t.Errorf("ecpay_shipments_total = %v, want 1 after one create and repeated queries", got)
+	}
+	if got := counter(t, registry, "ecpay_requests_total", map[string]string{"endpoint": "Helper/QueryLogisticsTradeInfo/V4", "outcome": ecpayprom.OutcomeSuccess}); got != 3 {
+		t.Errorf("successful queries = %v, want 3", got)
+	}
+	if got := counter(t, registry, "ecpay_requests_total", map[string]string{"endpoint": "Helper/QueryLogisticsTradeInfo/V4"}); got != 6 {
+		t.Errorf("queries = %v, want 6", got)
+	}
+}
@@ -0,0 +1,181 @@
+package ecpaytest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/img21326/ecpay"
+)
+
+type Shipment struct {
+	MerchantID       string
+	MerchantTradeNo  string
+	LogisticsID      string
+	LogisticsSubType string
+	Amount           ecpay.Money
+	GoodsName        string
+	ReceiverName     string
+	ReceiverPhone    string
+	ReceiverEmail    string
+	ReceiverStoreID  string
+	ServerReplyURL   string
+	CVSPaymentNo     string
+	CVSValidationNo  string
+	TradeDate        time.Time
+
+	// RtnCode is the store chain specific status code, see ecpay.SevenStatus
+	// and friends.
+	RtnCode          string
+	UpdateStatusDate time.Time
+
+	script []string
+}
+
+func (sh *Shipment) storeType() string {
+	return ecpay.TransferStoreType(sh.LogisticsSubType)
+}
+
+func (sh *Shipment) Status() string {
+	return ecpay.TransferStatus(sh.storeType(), sh.RtnCode)
+}
+
+func (s *Server) Shipment(merchantTradeNo string) (Shipment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.shipments[merchantTradeNo]
+	if !ok {
+		return Shipment{}, false
+	}
+	return *sh, true
+}
+
+// NotifyShipment moves the shipment to rtnCode and posts the status update
+// to its ServerReplyURL. rtnCode must belong to the shipment's store chain.
+func (s *Server) NotifyShipment(ctx context.Context, merchantTradeNo string, rtnCode string) error {
+	s.mu.Lock()
+	sh, ok := s.shipments[merchantTradeNo]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("ecpaytest: unknown shipment %v", merchantTradeNo)
+	}
+	if ecpay.TransferStatus(sh.storeType(), rtnCode) == ecpay.UNDEFINE {
+		s.mu.Unlock()
+		return fmt.Errorf("ecpaytest: status %v is not defined for %v", rtnCode, sh.LogisticsSubType)
+	}
+	sh.RtnCode = rtnCode
+	sh.UpdateStatusDate = time.Now()
+	values := s.shipmentValues(sh)
+	values.Set("RtnCode", sh.RtnCode)
+	values.Set("RtnMsg", shipmentMessage(sh))
+	values.Set("LogisticsSubType", sh.LogisticsSubType)
+	values.Set("UpdateStatusDate", sh.UpdateStatusDate.Format(timeLayout))
+	target := sh.ServerReplyURL
+	s.mu.Unlock()
+
+	return s.notify(ctx, target, sign(s.shipMac(), values))
+}
+
+func shipmentMessage(sh *Shipment) string {
+	if sh.RtnCode == "300" {
+		return "訂單處理中(已收到訂單資料)"
+	}
+	return sh.Status()
+}
+
+func (s *Server) shipmentValues(sh *Shipment) url.Values {
+	values := url.Values{}
+	values.Set("MerchantID", sh.MerchantID)
+	values.Set("MerchantTradeNo", sh.MerchantTradeNo)
+	values.Set("AllPayLogisticsID", sh.LogisticsID)
+	values.Set("LogisticsType", "CVS")
+	values.Set("GoodsAmount", sh.Amount.String())
+	values.Set("GoodsName", sh.GoodsName)
+	values.Set("ReceiverName", sh.ReceiverName)
+	values.Set("ReceiverPhone", sh.ReceiverPhone)
+	values.Set("ReceiverCellPhone", sh.ReceiverPhone)
+	values.Set("ReceiverEmail", sh.ReceiverEmail)
+	values.Set("ReceiverStoreID", sh.ReceiverStoreID)
+	values.Set("CVSPaymentNo", sh.CVSPaymentNo)
+	values.Set("CVSValidationNo", sh.CVSValidationNo)
+	values.Set("BookingNote", "")
+	return values
+}
+
+func (s *Server) handleCreateShipment(w http.ResponseWriter, r *http.Request) {
+	values, err := s.readForm(r, s.shipMac())
+	if err != nil {
+		writeText(w, "0|CheckMacValue Error")
+		return
+	}
+	subType := values.Get("LogisticsSubType")
+	if ecpay.TransferStoreType(subType) == "" {
+		writeText(w, "0|LogisticsSubType Error")
+		return
+	}
+	amount, err := ecpay.ParseMoney(values.Get("GoodsAmount"))
+	if err != nil {
+		writeText(w, "0|GoodsAmount Error")
+		return
+	}
+	tradeDate, _ := time.ParseInLocation(timeLayout, values.Get("MerchantTradeDate"), time.Local)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	merchantTradeNo := values.Get("MerchantTradeNo")
+	if _, ok := s.shipments[merchantTradeNo]; ok {
+		writeText(w, "0|廠商訂單編號重覆，請重新設定")
+		return
+	}
+	sh := &Shipment{
+		MerchantID:       values.Get("MerchantID"),
+		MerchantTradeNo:  merchantTradeNo,
+		LogisticsID:      s.nextID(""),
+		LogisticsSubType: subType,
+		Amount:           amount,
+		GoodsName:        values.Get("GoodsName"),
+		ReceiverName:     values.Get("ReceiverName"),
+		ReceiverPhone:    values.Get("ReceiverCellPhone"),
+		ReceiverEmail:    values.Get("ReceiverEmail"),
+		ReceiverStoreID:  values.Get("ReceiverStoreID"),
+		ServerReplyURL:   values.Get("ServerReplyURL"),
+		CVSPaymentNo:     s.nextID("C"),
+		TradeDate:        tradeDate,
+		RtnCode:          "300",
+		UpdateStatusDate: time.Now(),
+	}
+	if sh.storeType() == "711" {
+		sh.CVSValidationNo = "1234"
+	}
+	s.shipments[merchantTradeNo] = sh
+
+	resp := s.shipmentValues(sh)
+	resp.Set("RtnCode", sh.RtnCode)
+	resp.Set("RtnMsg", shipmentMessage(sh))
+	resp.Set("LogisticsSubType", sh.LogisticsSubType)
+	resp.Set("UpdateStatusDate", sh.UpdateStatusDate.Format(timeLayout))
+	writeText(w, "1|"+sign(s.shipMac(), resp).Encode())
+}
+
+func (s *Server) handleQueryShipment(w http.ResponseWriter, r *http.Request) {
+	values, err := s.readForm(r, s.shipMac())
+	if err != nil {
+		writeText(w, "0|CheckMacValue Error")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.shipments[values.Get("MerchantTradeNo")]
+	if !ok || sh.MerchantID != values.Get("MerchantID") {
+		writeText(w, "0|查無此筆訂單")
+		return
+	}
+	resp := s.shipmentValues(sh)
+	resp.Set("LogisticsType", "CVS_"+sh.LogisticsSubType)
+	resp.Set("LogisticsStatus", sh.RtnCode)
+	resp.Set("TradeDate", sh.TradeDate.Format(timeLayout))
+	resp.Set("HandlingCharge", "0")
+	writeText(w, sign(s.shipMac(), resp).Encode())
+}
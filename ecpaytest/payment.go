@@ -0,0 +1,275 @@
+package ecpaytest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/img21326/ecpay"
+)
+
+const (
+	CreditAuthorized = "已授權"
+	CreditClosing    = "要關帳"
+	CreditClosed     = "已關帳"
+	CreditCancelled  = "已取消"
+)
+
+type Payment struct {
+	MerchantID      string
+	MerchantTradeNo string
+	TradeNo         string
+	StoreID         string
+	Amount          ecpay.Money
+	ItemName        string
+	ReturnURL       string
+	TradeDate       time.Time
+
+	// TradeStatus is "0" until the payment is made and "1" afterwards.
+	TradeStatus string
+	PaymentType string
+	PaymentDate time.Time
+	Simulated   bool
+
+	// Credit card payments only.
+	RefundID       string
+	CreditStatus   string
+	RefundedAmount ecpay.Money
+}
+
+func (s *Server) Payment(merchantTradeNo string) (Payment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.payments[merchantTradeNo]
+	if !ok {
+		return Payment{}, false
+	}
+	return *p, true
+}
+
+// Pay completes the payment and posts the notification to its ReturnURL.
+// paymentType is an ECPay PaymentType such as "Credit_CreditCard" or
+// "ATM_TAISHIN".
+func (s *Server) Pay(ctx context.Context, merchantTradeNo string, paymentType string) error {
+	return s.pay(ctx, merchantTradeNo, paymentType, false)
+}
+
+// SimulatePay behaves like the back office "simulate payment" button, the
+// notification carries SimulatePaid=1.
+func (s *Server) SimulatePay(ctx context.Context, merchantTradeNo string) error {
+	return s.pay(ctx, merchantTradeNo, "Credit_CreditCard", true)
+}
+
+func (s *Server) pay(ctx context.Context, merchantTradeNo string, paymentType string, simulated bool) error {
+	s.mu.Lock()
+	p, ok := s.payments[merchantTradeNo]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("ecpaytest: unknown payment %v", merchantTradeNo)
+	}
+	p.TradeStatus = "1"
+	p.PaymentType = paymentType
+	p.PaymentDate = time.Now()
+	p.Simulated = simulated
+	if strings.HasPrefix(paymentType, "Credit") {
+		p.CreditStatus = CreditAuthorized
+		p.RefundID = s.nextID("")
+	}
+	values := url.Values{}
+	values.Set("MerchantID", p.MerchantID)
+	values.Set("MerchantTradeNo", p.MerchantTradeNo)
+	values.Set("StoreID", p.StoreID)
+	values.Set("RtnCode", "1")
+	values.Set("RtnMsg", "交易成功")
+	values.Set("TradeNo", p.TradeNo)
+	values.Set("TradeAmt", p.Amount.String())
+	values.Set("PaymentDate", p.PaymentDate.Format(timeLayout))
+	values.Set("PaymentType", p.PaymentType)
+	values.Set("PaymentTypeChargeFee", "0")
+	values.Set("TradeDate", p.TradeDate.Format(timeLayout))
+	values.Set("SimulatePaid", "0")
+	if simulated {
+		values.Set("SimulatePaid", "1")
+	}
+	if p.RefundID != "" {
+		values.Set("gwsr", p.RefundID)
+		values.Set("auth_code", "777777")
+		values.Set("card4no", "2222")
+		values.Set("process_date", p.PaymentDate.Format(timeLayout))
+	}
+	target := p.ReturnURL
+	s.mu.Unlock()
+
+	return s.notify(ctx, target, sign(s.paymentMac(), values))
+}
+
+// SetCreditStatus moves a credit card payment to one of the Credit*
+// statuses, e.g. CreditClosed to test refunds of settled trades.
+func (s *Server) SetCreditStatus(merchantTradeNo string, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.payments[merchantTradeNo]
+	if !ok || p.RefundID == "" {
+		return fmt.Errorf("ecpaytest: no credit card payment %v", merchantTradeNo)
+	}
+	p.CreditStatus = status
+	return nil
+}
+
+func (s *Server) handleCheckout(w http.ResponseWriter, r *http.Request) {
+	values, err := s.readForm(r, s.paymentMac())
+	if err != nil {
+		writeText(w, fmt.Sprintf("10200073|%v", err))
+		return
+	}
+	amount, err := ecpay.ParseMoney(values.Get("TotalAmount"))
+	if err != nil {
+		writeText(w, fmt.Sprintf("10200050|TotalAmount Error: %v", err))
+		return
+	}
+	tradeDate, _ := time.ParseInLocation(timeLayout, values.Get("MerchantTradeDate"), time.Local)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	merchantTradeNo := values.Get("MerchantTradeNo")
+	if _, ok := s.payments[merchantTradeNo]; ok {
+		writeText(w, "10300028|MerchantTradeNo is duplicate")
+		return
+	}
+	s.payments[merchantTradeNo] = &Payment{
+		MerchantID:      values.Get("MerchantID"),
+		MerchantTradeNo: merchantTradeNo,
+		TradeNo:         s.nextID(""),
+		StoreID:         values.Get("StoreID"),
+		Amount:          amount,
+		ItemName:        values.Get("ItemName"),
+		ReturnURL:       values.Get("ReturnURL"),
+		TradeDate:       tradeDate,
+		TradeStatus:     "0",
+	}
+	writeText(w, "OK")
+}
+
+func (s *Server) handleQueryTradeInfo(w http.ResponseWriter, r *http.Request) {
+	values, err := s.readForm(r, s.paymentMac())
+	if err != nil {
+		writeText(w, fmt.Sprintf("TradeStatus=10200073&RtnMsg=%v", url.QueryEscape(err.Error())))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := url.Values{}
+	resp.Set("MerchantID", values.Get("MerchantID"))
+	resp.Set("MerchantTradeNo", values.Get("MerchantTradeNo"))
+	p, ok := s.payments[values.Get("MerchantTradeNo")]
+	if !ok || p.MerchantID != values.Get("MerchantID") {
+		resp.Set("TradeStatus", "10200047")
+		resp.Set("RtnMsg", "Cant not find the trade data.")
+		writeText(w, sign(s.paymentMac(), resp).Encode())
+		return
+	}
+	resp.Set("StoreID", p.StoreID)
+	resp.Set("TradeNo", p.TradeNo)
+	resp.Set("TradeAmt", p.Amount.String())
+	resp.Set("PaymentType", p.PaymentType)
+	resp.Set("PaymentTypeChargeFee", "0")
+	resp.Set("HandlingCharge", "0")
+	resp.Set("TradeDate", p.TradeDate.Format(timeLayout))
+	resp.Set("TradeStatus", p.TradeStatus)
+	resp.Set("ItemName", p.ItemName)
+	if !p.PaymentDate.IsZero() {
+		resp.Set("PaymentDate", p.PaymentDate.Format(timeLayout))
+	}
+	writeText(w, sign(s.paymentMac(), resp).Encode())
+}
+
+func (s *Server) handleQueryCreditTrade(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	values, err := s.readForm(r, s.paymentMac())
+	if err == nil && values.Get("CreditCheckCode") != s.config.CreditCheckKey {
+		err = fmt.Errorf("CreditCheckCode Error")
+	}
+	if err != nil {
+		json.NewEncoder(w).Encode(map[string]string{"RtnCode": "0", "RtnMsg": err.Error()})
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found *Payment
+	for _, p := range s.payments {
+		if p.RefundID != "" && p.RefundID == values.Get("CreditRefundId") && p.MerchantID == values.Get("MerchantID") {
+			found = p
+			break
+		}
+	}
+	if found == nil {
+		json.NewEncoder(w).Encode(map[string]string{"RtnCode": "0", "RtnMsg": "查無交易資料"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"RtnMsg": "",
+		"RtnValue": map[string]interface{}{
+			"TradeID":    found.TradeNo,
+			"amount":     found.Amount.Int64(),
+			"clsamt":     (found.Amount - found.RefundedAmount).Int64(),
+			"authtime":   found.PaymentDate.Format(timeLayout),
+			"status":     found.CreditStatus,
+			"close_data": []interface{}{},
+		},
+	})
+}
+
+func (s *Server) handleDoAction(w http.ResponseWriter, r *http.Request) {
+	values, err := s.readForm(r, s.paymentMac())
+	if err != nil {
+		writeText(w, "RtnCode=0&RtnMsg="+url.QueryEscape(err.Error()))
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := url.Values{}
+	resp.Set("MerchantID", values.Get("MerchantID"))
+	resp.Set("MerchantTradeNo", values.Get("MerchantTradeNo"))
+	resp.Set("TradeNo", values.Get("TradeNo"))
+
+	p, ok := s.payments[values.Get("MerchantTradeNo")]
+	if !ok || p.MerchantID != values.Get("MerchantID") || p.TradeNo != values.Get("TradeNo") || p.RefundID == "" {
+		resp.Set("RtnCode", "0")
+		resp.Set("RtnMsg", "查無交易資料")
+		writeText(w, resp.Encode())
+		return
+	}
+	amount, _ := ecpay.ParseMoney(values.Get("TotalAmount"))
+	if err := doCreditAction(p, values.Get("Action"), amount); err != nil {
+		resp.Set("RtnCode", "0")
+		resp.Set("RtnMsg", err.Error())
+		writeText(w, resp.Encode())
+		return
+	}
+	resp.Set("RtnCode", "1")
+	resp.Set("RtnMsg", "成功.")
+	writeText(w, resp.Encode())
+}
+
+func doCreditAction(p *Payment, action string, amount ecpay.Money) error {
+	switch {
+	case action == "C" && p.CreditStatus == CreditAuthorized:
+		p.CreditStatus = CreditClosing
+	case action == "E" && p.CreditStatus == CreditClosing:
+		p.CreditStatus = CreditAuthorized
+	case action == "N" && p.CreditStatus == CreditAuthorized:
+		p.CreditStatus = CreditCancelled
+	case action == "R" && p.CreditStatus == CreditClosed:
+		if amount <= 0 || p.RefundedAmount+amount > p.Amount {
+			return fmt.Errorf("退刷金額錯誤")
+		}
+		p.RefundedAmount += amount
+	default:
+		return fmt.Errorf("交易狀態 %v 不可執行 %v", p.CreditStatus, action)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package ecpaytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/img21326/ecpay"
+)
+
+// volatileFields change on every run and are not compared on replay.
+var volatileFields = []string{"CheckMacValue", "TimeStamp", "MerchantTradeDate"}
+
+type Interaction struct {
+	Method      string     `json:"method"`
+	URL         string     `json:"url"`
+	Form        url.Values `json:"form"`
+	Status      int        `json:"status"`
+	ContentType string     `json:"content_type"`
+	Body        string     `json:"body"`
+}
+
+type cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+func readRequestForm(req *http.Request) (url.Values, error) {
+	if req.Body == nil {
+		return url.Values{}, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return url.ParseQuery(string(body))
+}
+
+// Recorder is an http.RoundTripper that forwards requests to Transport and
+// keeps each exchange with the secrets of config and card data masked by
+// ecpay.Redactor.
+// Call Save once the session is over.
+type Recorder struct {
+	Transport http.RoundTripper
+
+	path   string
+	redact ecpay.Redactor
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+func NewRecorder(path string, config ecpay.EcpayConfig, transport http.RoundTripper) *Recorder {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return &Recorder{
+		Transport: transport,
+		path:      path,
+		redact:    ecpay.NewRedactor(config),
+	}
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	form, err := readRequestForm(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, Interaction{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		Form:        r.redact.Values(form),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        r.redact.Body(string(body)),
+	})
+	return resp, nil
+}
+
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(cassette{Interactions: r.interactions}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(data, '\n'), 0o644)
+}
+
+// Replayer is an http.RoundTripper that answers requests from a file saved
+// by Recorder, in recorded order. A request whose method, path or form
+// differs from the recording fails with an error describing the mismatch.
+type Replayer struct {
+	redact ecpay.Redactor
+
+	mu           sync.Mutex
+	interactions []Interaction
+	next         int
+}
+
+func NewReplayer(path string, config ecpay.EcpayConfig) (*Replayer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("ecpaytest: read %v: %w", path, err)
+	}
+	return &Replayer{
+		redact:       ecpay.NewRedactor(config),
+		interactions: c.Interactions,
+	}, nil
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	form, err := readRequestForm(req)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next >= len(r.interactions) {
+		return nil, fmt.Errorf("ecpaytest: unexpected request %v %v, all %d recorded requests were used", req.Method, req.URL.Path, len(r.interactions))
+	}
+	recorded := r.interactions[r.next]
+	if err := matchInteraction(recorded, req, r.redact.Values(form)); err != nil {
+		return nil, fmt.Errorf("ecpaytest: request %d does not match recording: %w", r.next+1, err)
+	}
+	r.next++
+
+	header := http.Header{}
+	if recorded.ContentType != "" {
+		header.Set("Content-Type", recorded.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Done reports an error when recorded requests were never replayed.
+func (r *Replayer) Done() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.next < len(r.interactions) {
+		return fmt.Errorf("ecpaytest: %d of %d recorded requests were not replayed", len(r.interactions)-r.next, len(r.interactions))
+	}
+	return nil
+}
+
+func matchInteraction(recorded Interaction, req *http.Request, form url.Values) error {
+	recordedURL, err := url.Parse(recorded.URL)
+	if err != nil {
+		return err
+	}
+	if recorded.Method != req.Method || recordedURL.Path != req.URL.Path {
+		return fmt.Errorf("got %v %v, recorded %v %v", req.Method, req.URL.Path, recorded.Method, recordedURL.Path)
+	}
+	keys := make(map[string]bool)
+	for key := range form {
+		keys[key] = true
+	}
+	for key := range recorded.Form {
+		keys[key] = true
+	}
+	for _, key := range volatileFields {
+		delete(keys, key)
+	}
+	var diffs []string
+	for key := range keys {
+		if got, want := form.Get(key), recorded.Form.Get(key); got != want {
+			diffs = append(diffs, fmt.Sprintf("%v: got %q, recorded %q", key, got, want))
+		}
+	}
+	if len(diffs) > 0 {
+		sort.Strings(diffs)
+		return fmt.Errorf("%v", strings.Join(diffs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package ecpaytest_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+func createShipOrder(ec ecpay.Ecpay, receiverName string) error {
+	_, err := ec.CreateShipOrder(ecpay.CreateShippingOrderConfig{
+		MerchantTradeNo:   "S1",
+		TradeDate:         time.Date(2023, 8, 1, 12, 0, 0, 0, time.UTC),
+		ShippingStoreType: "711",
+		Amount:            100,
+		EntreeName:        "goods",
+		ReceiverName:      receiverName,
+		ReceiverPhone:     "0911111111",
+		ReceiverStoreID:   "131386",
+	})
+	return err
+}
+
+func TestReplayer(t *testing.T) {
+	config := testConfig()
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder := ecpaytest.NewRecorder(path, config, nil)
+	if err := createShipOrder(srv.Client(ecpay.WithTransport(recorder)), "receiver"); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	replay := func(receiverName string) error {
+		replayer, err := ecpaytest.NewReplayer(path, config)
+		if err != nil {
+			t.Fatalf("NewReplayer: %v", err)
+		}
+		if err := createShipOrder(srv.Client(ecpay.WithTransport(replayer)), receiverName); err != nil {
+			return err
+		}
+		return replayer.Done()
+	}
+	if err := replay("receiver"); err != nil {
+		t.Errorf("replaying the recorded request: %v", err)
+	}
+	err := replay("someone else")
+	if err == nil || !strings.Contains(err.Error(), "ReceiverName") {
+		t.Errorf("replaying a changed request error = %v, want a ReceiverName mismatch", err)
+	}
+}
+
+func TestRecorderRedacts(t *testing.T) {
+	config := testConfig()
+	config.HashKey = "key+with/escapes"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "MerchantTradeNo=T1&TradeAmt=100&card4no=2222&auth_code=777777&WebATMAccNo=12345&Echo=key%2Bwith%2Fescapes")
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder := ecpaytest.NewRecorder(path, config, nil)
+	ec := ecpay.NewEcpay(config, ecpay.WithTransport(recorder), ecpay.WithPaymentBaseURL(srv.URL))
+	ec.QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "T1"})
+	if err := recorder.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	for _, leaked := range []string{"2222", "777777", "12345", config.HashKey, "key%2Bwith%2Fescapes", config.HashIV} {
+		if strings.Contains(string(data), leaked) {
+			t.Errorf("recording contains %q", leaked)
+		}
+	}
+	if !strings.Contains(string(data), "TradeAmt=100") {
+		t.Error("recording lost the unmasked fields")
+	}
+}
@@ -0,0 +1,176 @@
+// Package ecpaytest provides an in-memory imitation of the ECPay payment and
+// logistics APIs for integration tests.
+package ecpaytest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/img21326/ecpay"
+)
+
+const timeLayout = "2006/01/02 15:04:05"
+
+type Server struct {
+	URL string
+
+	config ecpay.EcpayConfig
+	srv    *httptest.Server
+	client *http.Client
+
+	mu        sync.Mutex
+	seq       int
+	payments  map[string]*Payment
+	shipments map[string]*Shipment
+}
+
+// NewServer starts a server that signs and verifies CheckMacValue with the
+// keys of config. Close it when the test ends.
+func NewServer(config ecpay.EcpayConfig) *Server {
+	s := &Server{
+		config:    config,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		payments:  make(map[string]*Payment),
+		shipments: make(map[string]*Shipment),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Cashier/AioCheckOut/V5", s.handleCheckout)
+	mux.HandleFunc("/Cashier/QueryTradeInfo/V5", s.handleQueryTradeInfo)
+	mux.HandleFunc("/CreditDetail/QueryTrade/V2", s.handleQueryCreditTrade)
+	mux.HandleFunc("/CreditDetail/DoAction", s.handleDoAction)
+	mux.HandleFunc("/Express/Create", s.handleCreateShipment)
+	mux.HandleFunc("/Helper/QueryLogisticsTradeInfo/V4", s.handleQueryShipment)
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Options points an ecpay client at the server.
+func (s *Server) Options() []ecpay.Option {
+	return []ecpay.Option{
+		ecpay.WithPaymentBaseURL(s.URL),
+		ecpay.WithLogisticsBaseURL(s.URL),
+	}
+}
+
+// Client returns an ecpay client using the server's configuration.
+func (s *Server) Client(opts ...ecpay.Option) ecpay.EcpayContext {
+	return ecpay.NewEcpay(s.config, append(s.Options(), opts...)...)
+}
+
+var (
+	formActionPattern = regexp.MustCompile(`action="([^"]*)"`)
+	formInputPattern  = regexp.MustCompile(`name="([^"]*)" value="([^"]*)"`)
+)
+
+// SubmitForm posts the auto-submitting form returned by CreatePaymentOrder
+// the way a browser would.
+func (s *Server) SubmitForm(ctx context.Context, html string) error {
+	action := formActionPattern.FindStringSubmatch(html)
+	if action == nil {
+		return fmt.Errorf("ecpaytest: no form action in html")
+	}
+	values := url.Values{}
+	for _, input := range formInputPattern.FindAllStringSubmatch(html, -1) {
+		values.Set(input[1], input[2])
+	}
+	body, err := s.postForm(ctx, action[1], values)
+	if err != nil {
+		return err
+	}
+	if body != "OK" {
+		return fmt.Errorf("ecpaytest: checkout rejected: %v", body)
+	}
+	return nil
+}
+
+func (s *Server) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s%s%04d", prefix, time.Now().Format("0601021504"), s.seq)
+}
+
+func (s *Server) paymentMac() ecpay.CheckMacValueService {
+	return ecpay.NewPaymentMacValue(s.config)
+}
+
+func (s *Server) shipMac() ecpay.CheckMacValueService {
+	return ecpay.NewShipMacValue(s.config)
+}
+
+func (s *Server) readForm(r *http.Request, mac ecpay.CheckMacValueService) (url.Values, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	if err := ecpay.VerifyCheckMacValue(mac, r.PostForm); err != nil {
+		return nil, err
+	}
+	if merchantID := r.PostForm.Get("MerchantID"); merchantID != s.config.MerchantID && !s.isSubMerchant(r.PostForm) {
+		return nil, fmt.Errorf("unknown MerchantID %q", merchantID)
+	}
+	return r.PostForm, nil
+}
+
+// isSubMerchant accepts any sub-merchant of a platform config, requests
+// for them must carry its PlatformID.
+func (s *Server) isSubMerchant(values url.Values) bool {
+	return s.config.PlatformID != "" && values.Get("PlatformID") == s.config.PlatformID
+}
+
+func sign(mac ecpay.CheckMacValueService, values url.Values) url.Values {
+	params := make(map[string]string, len(values))
+	for key := range values {
+		params[key] = values.Get(key)
+	}
+	values.Set("CheckMacValue", mac.GenerateCheckMacValue(params))
+	return values
+}
+
+// notify posts a signed callback and expects ECPay's "1|OK" acknowledgement.
+func (s *Server) notify(ctx context.Context, target string, values url.Values) error {
+	if target == "" {
+		return fmt.Errorf("ecpaytest: no callback url")
+	}
+	body, err := s.postForm(ctx, target, values)
+	if err != nil {
+		return err
+	}
+	if body != "1|OK" {
+		return fmt.Errorf("ecpaytest: callback to %v answered %q", target, body)
+	}
+	return nil
+}
+
+func (s *Server) postForm(ctx context.Context, target string, values url.Values) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(values.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+func writeText(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, body)
+}
@@ -0,0 +1,112 @@
+package ecpaytest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/img21326/ecpay"
+)
+
+// Status flows a shipment can follow after it was created. They are
+// resolved to store chain specific codes with ecpay.StatusCodes.
+var (
+	PickedUpFlow = []string{
+		ecpay.SELLER_SEND_TO_STORE,
+		ecpay.DELIVERED,
+		ecpay.BUYER_PICK_UP,
+	}
+	ReturnedFlow = []string{
+		ecpay.SELLER_SEND_TO_STORE,
+		ecpay.DELIVERED,
+		ecpay.BUYER_DIDNT_PICK_UP,
+		ecpay.RETURN_TO_STORE,
+		ecpay.RETURNED,
+	}
+)
+
+// ScriptShipment sets the RtnCodes AdvanceShipment will send, e.g.
+// "2068", "2073", "2067" for 7-11. Every code must belong to the shipment's
+// store chain.
+func (s *Server) ScriptShipment(merchantTradeNo string, codes ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.shipments[merchantTradeNo]
+	if !ok {
+		return fmt.Errorf("ecpaytest: unknown shipment %v", merchantTradeNo)
+	}
+	for _, code := range codes {
+		if ecpay.TransferStatus(sh.storeType(), code) == ecpay.UNDEFINE {
+			return fmt.Errorf("ecpaytest: status %v is not defined for %v", code, sh.LogisticsSubType)
+		}
+	}
+	sh.script = append([]string(nil), codes...)
+	return nil
+}
+
+// ScriptShipmentFlow scripts the shipment through normalized statuses such
+// as PickedUpFlow. Each status is sent with the lowest code the store chain
+// defines for it.
+func (s *Server) ScriptShipmentFlow(merchantTradeNo string, flow []string) error {
+	sh, ok := s.Shipment(merchantTradeNo)
+	if !ok {
+		return fmt.Errorf("ecpaytest: unknown shipment %v", merchantTradeNo)
+	}
+	codes := make([]string, 0, len(flow))
+	for _, status := range flow {
+		candidates := ecpay.StatusCodes(sh.storeType(), status)
+		if len(candidates) == 0 {
+			return fmt.Errorf("ecpaytest: status %v is not defined for %v", status, sh.LogisticsSubType)
+		}
+		codes = append(codes, candidates[0])
+	}
+	return s.ScriptShipment(merchantTradeNo, codes...)
+}
+
+// AdvanceShipment sends the next scripted status. Unscripted shipments
+// follow PickedUpFlow. It returns the code sent and whether the script is
+// finished.
+func (s *Server) AdvanceShipment(ctx context.Context, merchantTradeNo string) (string, bool, error) {
+	s.mu.Lock()
+	sh, ok := s.shipments[merchantTradeNo]
+	scripted := ok && sh.script != nil
+	s.mu.Unlock()
+	if !ok {
+		return "", true, fmt.Errorf("ecpaytest: unknown shipment %v", merchantTradeNo)
+	}
+	if !scripted {
+		if err := s.ScriptShipmentFlow(merchantTradeNo, PickedUpFlow); err != nil {
+			return "", true, err
+		}
+	}
+
+	s.mu.Lock()
+	if len(sh.script) == 0 {
+		s.mu.Unlock()
+		return "", true, nil
+	}
+	code := sh.script[0]
+	sh.script = sh.script[1:]
+	done := len(sh.script) == 0
+	s.mu.Unlock()
+
+	return code, done, s.NotifyShipment(ctx, merchantTradeNo, code)
+}
+
+// RunShipment advances the shipment every interval until its script is
+// finished, the context is done or a callback fails.
+func (s *Server) RunShipment(ctx context.Context, merchantTradeNo string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		_, done, err := s.AdvanceShipment(ctx, merchantTradeNo)
+		if err != nil || done {
+			return err
+		}
+	}
+}
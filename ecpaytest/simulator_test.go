@@ -0,0 +1,132 @@
+package ecpaytest_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+func testConfig() ecpay.EcpayConfig {
+	return ecpay.EcpayConfig{
+		MerchantID:  "2000933",
+		HashKey:     "XBERn1YOvpM9nfZc",
+		HashIV:      "h1ONHk4P4yqbl5LK",
+		SenderName:  "sender",
+		SenderPhone: "0912345678",
+	}
+}
+
+type callbacks struct {
+	mu       sync.Mutex
+	statuses []string
+}
+
+func (c *callbacks) get() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.statuses...)
+}
+
+// newShipment creates a 7-11 shipment whose callbacks are collected.
+func newShipment(t *testing.T, merchantTradeNo string) (*ecpaytest.Server, *callbacks) {
+	t.Helper()
+	config := testConfig()
+	received := &callbacks{}
+	callbackSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if _, err := ecpay.VerifyShipCheckMacValue(config, r.PostForm); err != nil {
+			t.Errorf("callback: %v", err)
+		}
+		resp, _ := ecpay.NewEcpay(config).ParseShipOrderResponse(r.PostForm.Encode())
+		received.mu.Lock()
+		received.statuses = append(received.statuses, resp.Status)
+		received.mu.Unlock()
+		io.WriteString(w, "1|OK")
+	}))
+	t.Cleanup(callbackSrv.Close)
+	config.ShipServerReplyURL = callbackSrv.URL
+
+	srv := ecpaytest.NewServer(config)
+	t.Cleanup(srv.Close)
+	_, err := srv.Client().CreateShipOrder(ecpay.CreateShippingOrderConfig{
+		MerchantTradeNo:   merchantTradeNo,
+		TradeDate:         time.Now(),
+		ShippingStoreType: "711",
+		Amount:            100,
+		EntreeName:        "goods",
+		ReceiverName:      "receiver",
+		ReceiverPhone:     "0911111111",
+		ReceiverStoreID:   "131386",
+	})
+	if err != nil {
+		t.Fatalf("CreateShipOrder: %v", err)
+	}
+	return srv, received
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRunShipmentFlow(t *testing.T) {
+	srv, received := newShipment(t, "S1")
+	if err := srv.ScriptShipmentFlow("S1", ecpaytest.ReturnedFlow); err != nil {
+		t.Fatalf("ScriptShipmentFlow: %v", err)
+	}
+	if err := srv.RunShipment(context.Background(), "S1", time.Millisecond); err != nil {
+		t.Fatalf("RunShipment: %v", err)
+	}
+	if got := received.get(); !equal(got, ecpaytest.ReturnedFlow) {
+		t.Errorf("callbacks = %v, want %v", got, ecpaytest.ReturnedFlow)
+	}
+	sh, _ := srv.Shipment("S1")
+	if sh.Status() != ecpay.RETURNED {
+		t.Errorf("shipment status = %v, want %v", sh.Status(), ecpay.RETURNED)
+	}
+}
+
+func TestAdvanceShipment(t *testing.T) {
+	srv, received := newShipment(t, "S1")
+	ctx := context.Background()
+	for i := range ecpaytest.PickedUpFlow {
+		_, done, err := srv.AdvanceShipment(ctx, "S1")
+		if err != nil {
+			t.Fatalf("AdvanceShipment: %v", err)
+		}
+		if done != (i == len(ecpaytest.PickedUpFlow)-1) {
+			t.Errorf("step %d: done = %v", i, done)
+		}
+	}
+	if got := received.get(); !equal(got, ecpaytest.PickedUpFlow) {
+		t.Errorf("callbacks = %v, want %v", got, ecpaytest.PickedUpFlow)
+	}
+	if code, done, err := srv.AdvanceShipment(ctx, "S1"); code != "" || !done || err != nil {
+		t.Errorf("AdvanceShipment after the flow = %q, %v, %v", code, done, err)
+	}
+}
+
+func TestScriptShipmentRejectsOtherChains(t *testing.T) {
+	srv, _ := newShipment(t, "S1")
+	// 3024 is a FamilyMart code.
+	if err := srv.ScriptShipment("S1", "2068", "3024"); err == nil {
+		t.Error("ScriptShipment accepted a code of another store chain")
+	}
+	if err := srv.ScriptShipment("S2", "2068"); err == nil {
+		t.Error("ScriptShipment accepted an unknown shipment")
+	}
+}
@@ -0,0 +1,104 @@
+package ecpay
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrDuplicateTradeNo = errors.New("duplicate MerchantTradeNo")
+	ErrInvalidStore     = errors.New("invalid store")
+	ErrAmountOutOfRange = errors.New("amount out of range")
+	ErrTradeNotFound    = errors.New("trade not found")
+	// ErrRefundNotAllowed is returned for credit trades in a status
+	// RefundPayment has no action for, e.g. already cancelled.
+	ErrRefundNotAllowed = errors.New("trade cannot be refunded")
+
+	ErrDuplicateCreateShip = fmt.Errorf("duplicate create ship order: %w", ErrDuplicateTradeNo)
+)
+
+// APIError is returned when ECPay answers with a failure RtnCode. Err is
+// the matching sentinel from the error catalog, if any, so callers can use
+// errors.Is instead of comparing RtnMsg.
+type APIError struct {
+	Endpoint string
+	RtnCode  string
+	RtnMsg   string
+	Body     string
+	Err      error
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("ecpay %v: %v (RtnCode: %v, RtnMsg: %v)", e.Endpoint, e.Err, e.RtnCode, e.RtnMsg)
+	}
+	return fmt.Sprintf("ecpay %v: RtnCode: %v, RtnMsg: %v", e.Endpoint, e.RtnCode, e.RtnMsg)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// errorCatalogEntry matches on RtnCode when Code is set, otherwise on
+// RtnMsg containing any of Messages.
+type errorCatalogEntry struct {
+	Code     string
+	Messages []string
+	Err      error
+}
+
+var paymentErrorCatalog = []errorCatalogEntry{
+	{Code: "10200073", Err: ErrInvalidCheckMacValue},
+	{Code: "10200047", Err: ErrTradeNotFound},
+	{Code: "10300028", Err: ErrDuplicateTradeNo},
+	{Messages: []string{"CheckMacValue"}, Err: ErrInvalidCheckMacValue},
+	{Messages: []string{"重覆", "重複", "Duplicate"}, Err: ErrDuplicateTradeNo},
+	{Messages: []string{"金額", "Amount"}, Err: ErrAmountOutOfRange},
+	{Messages: []string{"查無", "not find", "not found"}, Err: ErrTradeNotFound},
+}
+
+var logisticsErrorCatalog = []errorCatalogEntry{
+	{Messages: []string{"CheckMacValue"}, Err: ErrInvalidCheckMacValue},
+	{Messages: []string{"廠商訂單編號重覆"}, Err: ErrDuplicateCreateShip},
+	{Messages: []string{"門市", "StoreID"}, Err: ErrInvalidStore},
+	{Messages: []string{"金額", "Amount"}, Err: ErrAmountOutOfRange},
+	{Messages: []string{"查無", "not found"}, Err: ErrTradeNotFound},
+}
+
+func lookupError(catalog []errorCatalogEntry, rtnCode string, rtnMsg string) error {
+	for _, entry := range catalog {
+		if entry.Code != "" && entry.Code == rtnCode {
+			return entry.Err
+		}
+	}
+	lowerMsg := strings.ToLower(rtnMsg)
+	for _, entry := range catalog {
+		for _, message := range entry.Messages {
+			if strings.Contains(lowerMsg, strings.ToLower(message)) {
+				return entry.Err
+			}
+		}
+	}
+	return nil
+}
+
+func newPaymentError(endpoint string, rtnCode string, rtnMsg string, body string) *APIError {
+	return &APIError{
+		Endpoint: endpoint,
+		RtnCode:  rtnCode,
+		RtnMsg:   rtnMsg,
+		Body:     body,
+		Err:      lookupError(paymentErrorCatalog, rtnCode, rtnMsg),
+	}
+}
+
+func newLogisticsError(endpoint string, rtnCode string, rtnMsg string, body string) *APIError {
+	return &APIError{
+		Endpoint: endpoint,
+		RtnCode:  rtnCode,
+		RtnMsg:   rtnMsg,
+		Body:     body,
+		Err:      lookupError(logisticsErrorCatalog, rtnCode, rtnMsg),
+	}
+}
@@ -0,0 +1,43 @@
+package ecpay_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+func TestQueryPaymentUnknownTrade(t *testing.T) {
+	srv := ecpaytest.NewServer(testConfig())
+	defer srv.Close()
+
+	_, err := srv.Client().QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "UNKNOWN1"})
+	var apiErr *ecpay.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("QueryPayment error = %v, want *ecpay.APIError", err)
+	}
+	if !errors.Is(err, ecpay.ErrTradeNotFound) {
+		t.Errorf("QueryPayment error = %v, want ErrTradeNotFound", err)
+	}
+	if apiErr.RtnCode != "10200047" {
+		t.Errorf("RtnCode = %q, want 10200047", apiErr.RtnCode)
+	}
+}
+
+func TestInvalidCheckMacValue(t *testing.T) {
+	srv := ecpaytest.NewServer(testConfig())
+	defer srv.Close()
+	config := testConfig()
+	config.HashKey = "5294y06JbISpM5x9"
+	ec := ecpay.NewEcpay(config, srv.Options()...)
+
+	_, err := ec.QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "T1"})
+	if !errors.Is(err, ecpay.ErrInvalidCheckMacValue) {
+		t.Errorf("QueryPayment error = %v, want ErrInvalidCheckMacValue", err)
+	}
+	_, err = ec.CreateShipOrder(shipOrderConfig("S1"))
+	if !errors.Is(err, ecpay.ErrInvalidCheckMacValue) {
+		t.Errorf("CreateShipOrder error = %v, want ErrInvalidCheckMacValue", err)
+	}
+}
@@ -0,0 +1,194 @@
+package ecpay
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// Logger is satisfied by *slog.Logger.
+type Logger interface {
+	DebugContext(ctx context.Context, msg string, args ...any)
+	InfoContext(ctx context.Context, msg string, args ...any)
+	WarnContext(ctx context.Context, msg string, args ...any)
+	ErrorContext(ctx context.Context, msg string, args ...any)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) DebugContext(ctx context.Context, msg string, args ...any) {}
+func (nopLogger) InfoContext(ctx context.Context, msg string, args ...any)  {}
+func (nopLogger) WarnContext(ctx context.Context, msg string, args ...any)  {}
+func (nopLogger) ErrorContext(ctx context.Context, msg string, args ...any) {}
+
+func WithLogger(logger Logger) Option {
+	return func(e *EcpayImpl) {
+		e.logger = logger
+	}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID sets the request ID logged for calls made with ctx. Calls
+// without one get a random ID.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+func ensureRequestID(ctx context.Context) (context.Context, string) {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return ctx, requestID
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	requestID := hex.EncodeToString(b)
+	return WithRequestID(ctx, requestID), requestID
+}
+
+const redactedValue = "[REDACTED]"
+
+// sensitiveFields are masked wherever parameters or bodies are logged.
+var sensitiveFields = map[string]bool{
+	"hashkey":         true,
+	"hashiv":          true,
+	"creditcheckcode": true,
+	"checkmacvalue":   true,
+	"card4no":         true,
+	"card6no":         true,
+	"auth_code":       true,
+	"webatmaccno":     true,
+	"webatmaccbank":   true,
+}
+
+func isSensitiveField(key string) bool {
+	return sensitiveFields[strings.ToLower(key)]
+}
+
+func (c EcpayConfig) secrets() []string {
+	var secrets []string
+	candidates := []string{c.HashKey, c.HashIV, c.CreditCheckKey}
+	for _, key := range c.AdditionalKeys {
+		candidates = append(candidates, key.HashKey, key.HashIV)
+	}
+	for _, secret := range candidates {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+func (c EcpayConfig) redactParams(params map[string]string) map[string]string {
+	return redactor{secrets: c.secrets(), sensitive: isSensitiveField}.params(params)
+}
+
+// redactBody masks sensitive fields of form encoded bodies and any
+// configured secret elsewhere.
+func (c EcpayConfig) redactBody(body string) string {
+	return redactor{secrets: c.secrets(), sensitive: isSensitiveField}.body(body)
+}
+
+func (c EcpayConfig) redactString(s string) string {
+	return redactor{secrets: c.secrets()}.string(s)
+}
+
+// Redactor masks the secrets of configs and card data in exchanges kept
+// outside the process, e.g. by ecpaytest.Recorder. CheckMacValue is kept so
+// the exchanges can still be verified.
+type Redactor struct {
+	r redactor
+}
+
+func NewRedactor(configs ...EcpayConfig) Redactor {
+	var secrets []string
+	for _, config := range configs {
+		secrets = append(secrets, config.secrets()...)
+	}
+	return Redactor{r: redactor{secrets: secrets, sensitive: isAuditSensitiveField}}
+}
+
+func (r Redactor) String(s string) string {
+	return r.r.string(s)
+}
+
+// Body masks the sensitive fields of form encoded bodies in place and the
+// secrets anywhere.
+func (r Redactor) Body(body string) string {
+	return r.r.body(body)
+}
+
+func (r Redactor) Values(values url.Values) url.Values {
+	redacted := make(url.Values, len(values))
+	for key, vs := range values {
+		for _, v := range vs {
+			if r.r.sensitive(key) {
+				v = redactedValue
+			}
+			redacted.Add(key, r.r.string(v))
+		}
+	}
+	return redacted
+}
+
+// redactor masks the values of sensitive fields and the given secrets.
+type redactor struct {
+	secrets   []string
+	sensitive func(key string) bool
+}
+
+func (r redactor) params(params map[string]string) map[string]string {
+	redacted := make(map[string]string, len(params))
+	for key, value := range params {
+		if r.sensitive(key) {
+			value = redactedValue
+		}
+		redacted[key] = r.string(value)
+	}
+	return redacted
+}
+
+// body masks form encoded fields in place, keeping the order and escaping
+// of everything else, so the result still reads like the original.
+func (r redactor) body(body string) string {
+	prefix := ""
+	query := body
+	if code, rest, found := strings.Cut(body, "|"); found && !strings.Contains(code, "=") {
+		prefix, query = code+"|", rest
+	}
+	if !strings.Contains(query, "=") {
+		return r.string(body)
+	}
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		rawKey, _, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		if r.sensitive(key) {
+			pairs[i] = rawKey + "=" + url.QueryEscape(redactedValue)
+		}
+	}
+	return r.string(prefix + strings.Join(pairs, "&"))
+}
+
+func (r redactor) string(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redactedValue)
+		// Secrets also show up form encoded in bodies.
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			s = strings.ReplaceAll(s, escaped, url.QueryEscape(redactedValue))
+		}
+	}
+	return s
+}
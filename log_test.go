@@ -0,0 +1,89 @@
+package ecpay_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+// captureLogger keeps every entry as one line of text.
+type captureLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *captureLogger) log(level string, msg string, args []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, fmt.Sprintf("%v %v %v", level, msg, args))
+}
+
+func (l *captureLogger) DebugContext(ctx context.Context, msg string, args ...any) {
+	l.log("DEBUG", msg, args)
+}
+
+func (l *captureLogger) InfoContext(ctx context.Context, msg string, args ...any) {
+	l.log("INFO", msg, args)
+}
+
+func (l *captureLogger) WarnContext(ctx context.Context, msg string, args ...any) {
+	l.log("WARN", msg, args)
+}
+
+func (l *captureLogger) ErrorContext(ctx context.Context, msg string, args ...any) {
+	l.log("ERROR", msg, args)
+}
+
+func (l *captureLogger) text() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.entries, "\n")
+}
+
+func TestLoggerRedactsRequests(t *testing.T) {
+	config := testConfig()
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+	logger := &captureLogger{}
+	ec := srv.Client(ecpay.WithLogger(logger))
+
+	ctx := ecpay.WithRequestID(context.Background(), "req-1")
+	ec.RefundPaymentContext(ctx, ecpay.RefundConfig{MerchantTradeNo: "T1", BankTransactionID: "1", RefundID: "2", Amount: 100})
+	ec.CreateShipOrderContext(ctx, shipOrderConfig("S1"))
+
+	logged := logger.text()
+	for _, secret := range []string{config.HashKey, config.HashIV, config.CreditCheckKey} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("log contains secret %q:\n%v", secret, logged)
+		}
+	}
+	for _, want := range []string{"req-1", "CreditDetail/QueryTrade/V2", "Express/Create", "CreditCheckCode:[REDACTED]"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log does not contain %q:\n%v", want, logged)
+		}
+	}
+}
+
+func TestNotifyLoggerRedactsCardData(t *testing.T) {
+	config := testConfig()
+	logger := &captureLogger{}
+	handler := ecpay.NewPaymentNotifyHandler(config, func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+		return nil
+	}, ecpay.WithNotifyLogger(logger))
+
+	postNotification(handler, notification(config, map[string]string{"card4no": "4321", "auth_code": "987654"}))
+	logged := logger.text()
+	for _, leaked := range []string{"4321", "987654"} {
+		if strings.Contains(logged, leaked) {
+			t.Errorf("log contains card data %q:\n%v", leaked, logged)
+		}
+	}
+	if !strings.Contains(logged, "ecpay notification handled") {
+		t.Errorf("notification not logged:\n%v", logged)
+	}
+}
@@ -0,0 +1,143 @@
+package ecpay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// Call describes one request to ECPay as seen by middleware. Params are the
+// signed parameters, including CheckMacValue. StatusCode, Body and Result
+// are filled in once the response arrives; Result holds the parsed answer,
+// e.g. a *PaymentResponse for Cashier/QueryTradeInfo/V5, and RtnCode the
+// code ECPay answered with, successful or not.
+type Call struct {
+	// Operation is the Ecpay method that issued the call, e.g.
+	// "RefundPayment" for each of its CreditDetail/DoAction steps.
+	Operation       string
+	Endpoint        string
+	MerchantTradeNo string
+	Params          map[string]string
+	// Header is sent along with the request, e.g. for trace propagation.
+	Header http.Header
+
+	StatusCode int
+	Body       string
+	RtnCode    string
+	Result     interface{}
+}
+
+type Invoker func(ctx context.Context, call *Call) error
+
+// Middleware wraps every call to ECPay. Middleware is shared by all
+// goroutines using the client and must not keep per-call state outside of
+// the Call and context.
+type Middleware func(next Invoker) Invoker
+
+// Chain composes middlewares, the first one being the outermost.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(next Invoker) Invoker {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(e *EcpayImpl) {
+		e.middlewares = append(e.middlewares, middlewares...)
+	}
+}
+
+func resultRtnCode(result interface{}, err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.RtnCode
+	}
+	switch r := result.(type) {
+	case *PaymentResponse:
+		return r.RtnCode
+	case *RefundResponse:
+		return r.RtnCode
+	case ShipOrderResponse:
+		return r.RtnCode
+	}
+	return ""
+}
+
+type NotificationType string
+
+const NotificationPayment NotificationType = "payment"
+
+type NotifyOutcome string
+
+const (
+	NotifyHandled NotifyOutcome = "handled"
+	// NotifyRejected notifications were acknowledged to ECPay but not
+	// passed on, see WithRejectHandler.
+	NotifyRejected NotifyOutcome = "rejected"
+	// NotifyInvalid notifications were malformed or failed CheckMacValue.
+	NotifyInvalid NotifyOutcome = "invalid"
+	// NotifyFailed notifications will be sent again by ECPay.
+	NotifyFailed NotifyOutcome = "failed"
+)
+
+// Notification describes one callback from ECPay as seen by notify
+// middleware. MerchantTradeNo and RtnCode are read before CheckMacValue is
+// verified; Payment and Outcome are filled in by the handler.
+type Notification struct {
+	Type            NotificationType
+	MerchantTradeNo string
+	RtnCode         string
+	Body            string
+	Values          url.Values
+
+	// KeyID names the key pair CheckMacValue was verified with.
+	KeyID   string
+	Payment *PaymentResponse
+	Outcome NotifyOutcome
+}
+
+type NotifyInvoker func(ctx context.Context, n *Notification) error
+
+type NotifyMiddleware func(next NotifyInvoker) NotifyInvoker
+
+// ChainNotify composes notify middlewares, the first one being the
+// outermost.
+func ChainNotify(middlewares ...NotifyMiddleware) NotifyMiddleware {
+	return func(next NotifyInvoker) NotifyInvoker {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			next = middlewares[i](next)
+		}
+		return next
+	}
+}
+
+func WithNotifyMiddleware(middlewares ...NotifyMiddleware) NotifyOption {
+	return func(o *notifyOptions) {
+		o.middlewares = append(o.middlewares, middlewares...)
+	}
+}
+
+type operationKey struct{}
+
+type operation struct {
+	name            string
+	merchantTradeNo string
+}
+
+// withOperation names the calls made with ctx, unless an outer operation
+// already did.
+func withOperation(ctx context.Context, name string, merchantTradeNo string) context.Context {
+	if _, ok := ctx.Value(operationKey{}).(operation); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, operationKey{}, operation{name: name, merchantTradeNo: merchantTradeNo})
+}
+
+func operationFromContext(ctx context.Context) operation {
+	op, _ := ctx.Value(operationKey{}).(operation)
+	return op
+}
@@ -0,0 +1,117 @@
+package ecpay
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of New Taiwan dollars. ECPay only accepts whole
+// dollars, so fractional values are rejected unless the caller rounds them
+// explicitly with RoundMoney.
+type Money int64
+
+const (
+	minShipAmount Money = 1
+	maxShipAmount Money = 20000
+
+	// maxMoney keeps float conversions exact.
+	maxMoney Money = 1 << 53
+)
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+func NewMoney(amount int64) (Money, error) {
+	m := Money(amount)
+	if m < 0 || m > maxMoney {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidAmount, amount)
+	}
+	return m, nil
+}
+
+// MoneyFromFloat converts amount without rounding. It fails when amount
+// has a fractional part.
+func MoneyFromFloat(amount float64) (Money, error) {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) || amount != math.Trunc(amount) {
+		return 0, fmt.Errorf("%w: %v is not a whole dollar amount", ErrInvalidAmount, amount)
+	}
+	if amount < 0 || amount > float64(maxMoney) {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidAmount, amount)
+	}
+	return Money(amount), nil
+}
+
+// RoundMoney rounds amount half away from zero, e.g. 10.5 becomes 11.
+func RoundMoney(amount float64) (Money, error) {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidAmount, amount)
+	}
+	return MoneyFromFloat(math.Round(amount))
+}
+
+// parseAmountField parses a required amount of an ECPay answer.
+func parseAmountField(values url.Values, key string) (Money, error) {
+	if !values.Has(key) {
+		return 0, fmt.Errorf("%w: missing %v", ErrInvalidAmount, key)
+	}
+	amount, err := ParseMoney(values.Get(key))
+	if err != nil {
+		return 0, fmt.Errorf("%v: %w", key, err)
+	}
+	return amount, nil
+}
+
+// parseFeeField rounds fees to the dollar, they may carry cents. A missing
+// fee is zero.
+func parseFeeField(values url.Values, key string) (Money, error) {
+	s := strings.TrimSpace(values.Get(key))
+	if s == "" {
+		return 0, nil
+	}
+	fee, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %w: %q", key, ErrInvalidAmount, s)
+	}
+	amount, err := RoundMoney(fee)
+	if err != nil {
+		return 0, fmt.Errorf("%v: %w", key, err)
+	}
+	return amount, nil
+}
+
+// ParseMoney parses an amount as returned by ECPay. Trailing zero decimals
+// such as "150.00" are accepted, other fractions are not.
+func ParseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if whole, frac, found := strings.Cut(s, "."); found && strings.Trim(frac, "0") == "" {
+		s = whole
+	}
+	amount, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	return NewMoney(amount)
+}
+
+func (m Money) Int64() int64 {
+	return int64(m)
+}
+
+func (m Money) String() string {
+	return strconv.FormatInt(int64(m), 10)
+}
+
+// Validate reports whether m can be charged, i.e. it is positive.
+func (m Money) Validate() error {
+	return m.validateRange(1, maxMoney)
+}
+
+func (m Money) validateRange(min Money, max Money) error {
+	if m < min || m > max {
+		return fmt.Errorf("%w: %d must be between %d and %d", ErrInvalidAmount, m, min, max)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package ecpay
+
+import (
+	"net/http"
+	"strings"
+)
+
+type Option func(*EcpayImpl)
+
+// WithHTTPClient sends every request through the transport, cookie jar,
+// redirect policy and timeout of client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(e *EcpayImpl) {
+		hc := e.client.GetClient()
+		hc.Transport = client.Transport
+		if hc.Transport == nil {
+			hc.Transport = http.DefaultTransport
+		}
+		hc.Jar = client.Jar
+		if client.CheckRedirect != nil {
+			hc.CheckRedirect = client.CheckRedirect
+		}
+		if client.Timeout > 0 {
+			hc.Timeout = client.Timeout
+		}
+	}
+}
+
+func WithTransport(transport http.RoundTripper) Option {
+	return func(e *EcpayImpl) {
+		e.client.GetClient().Transport = transport
+	}
+}
+
+// WithPaymentBaseURL replaces https://payment(-stage).ecpay.com.tw.
+func WithPaymentBaseURL(baseURL string) Option {
+	return func(e *EcpayImpl) {
+		e.paymentURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithLogisticsBaseURL replaces https://logistics(-stage).ecpay.com.tw.
+func WithLogisticsBaseURL(baseURL string) Option {
+	return func(e *EcpayImpl) {
+		e.shipURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
@@ -0,0 +1,49 @@
+package ecpay_test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+type recordingTransport struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.paths = append(t.paths, req.URL.Path)
+	t.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestBaseURLsAndHTTPClient(t *testing.T) {
+	config := testConfig()
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+	transport := &recordingTransport{}
+	ec := ecpay.NewEcpay(config,
+		ecpay.WithHTTPClient(&http.Client{Transport: transport}),
+		ecpay.WithPaymentBaseURL(srv.URL+"/"),
+		ecpay.WithLogisticsBaseURL(srv.URL))
+
+	if _, err := ec.CreateShipOrder(shipOrderConfig("S1")); err != nil {
+		t.Fatalf("CreateShipOrder: %v", err)
+	}
+	if _, err := ec.QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "UNKNOWN1"}); err == nil {
+		t.Fatal("QueryPayment of an unknown trade succeeded")
+	}
+	want := []string{"/Express/Create", "/Cashier/QueryTradeInfo/V5"}
+	if len(transport.paths) != len(want) {
+		t.Fatalf("requests = %v, want %v", transport.paths, want)
+	}
+	for i := range want {
+		if transport.paths[i] != want[i] {
+			t.Errorf("request %d = %v, want %v", i, transport.paths[i], want[i])
+		}
+	}
+}
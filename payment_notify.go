@@ -0,0 +1,316 @@
+package ecpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// ExpectedOrder is what an OrderLookup knows about a trade. MerchantID and
+// MerchantTradeNo default to the configured merchant and the trade looked
+// up.
+type ExpectedOrder struct {
+	MerchantID      string
+	MerchantTradeNo string
+	Amount          Money
+	AllowSimulation bool
+}
+
+type OrderLookup interface {
+	LookupOrder(ctx context.Context, merchantTradeNo string) (ExpectedOrder, error)
+}
+
+var ErrOrderNotFound = errors.New("order not found")
+var ErrNotificationMismatch = errors.New("notification does not match order")
+
+// ErrPlatformOrderLookup is returned in platform mode when there is no
+// OrderLookup, or it leaves ExpectedOrder.MerchantID empty.
+var ErrPlatformOrderLookup = errors.New("platform notifications need an order lookup with MerchantID")
+
+type NotificationMismatchError struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (e *NotificationMismatchError) Error() string {
+	return fmt.Sprintf("%v: %v expected %q, got %q", ErrNotificationMismatch, e.Field, e.Expected, e.Actual)
+}
+
+func (e *NotificationMismatchError) Unwrap() error {
+	return ErrNotificationMismatch
+}
+
+func VerifyPaymentNotification(resp *PaymentResponse, expected ExpectedOrder) error {
+	if resp.MerchantID != expected.MerchantID {
+		return &NotificationMismatchError{Field: "MerchantID", Expected: expected.MerchantID, Actual: resp.MerchantID}
+	}
+	if resp.TradeNo != expected.MerchantTradeNo {
+		return &NotificationMismatchError{Field: "MerchantTradeNo", Expected: expected.MerchantTradeNo, Actual: resp.TradeNo}
+	}
+	if resp.Amount != expected.Amount {
+		return &NotificationMismatchError{Field: "TradeAmt", Expected: expected.Amount.String(), Actual: resp.Amount.String()}
+	}
+	if resp.Simulation && !expected.AllowSimulation {
+		return &NotificationMismatchError{Field: "SimulatePaid", Expected: "0", Actual: "1"}
+	}
+	return nil
+}
+
+// SimulatedPaymentPolicy decides what happens to notifications of payments
+// simulated from the ECPay back office (SimulatePaid=1).
+type SimulatedPaymentPolicy int
+
+const (
+	// SimulatedPaymentAuto rejects simulated payments in production and
+	// flags them everywhere else.
+	SimulatedPaymentAuto SimulatedPaymentPolicy = iota
+	SimulatedPaymentReject
+	// SimulatedPaymentFlag passes simulated payments on with
+	// PaymentResponse.Simulation set.
+	SimulatedPaymentFlag
+)
+
+var ErrSimulatedPayment = errors.New("simulated payment rejected")
+
+type SimulatedPaymentError struct {
+	MerchantTradeNo string
+	IsProduction    bool
+}
+
+func (e *SimulatedPaymentError) Error() string {
+	return fmt.Sprintf("%v: trade %v, production: %v", ErrSimulatedPayment, e.MerchantTradeNo, e.IsProduction)
+}
+
+func (e *SimulatedPaymentError) Unwrap() error {
+	return ErrSimulatedPayment
+}
+
+func (c EcpayConfig) rejectsSimulatedPayment() bool {
+	switch c.SimulatedPayment {
+	case SimulatedPaymentReject:
+		return true
+	case SimulatedPaymentFlag:
+		return false
+	default:
+		return c.IsProduction
+	}
+}
+
+type PaymentNotifyFunc func(ctx context.Context, resp *PaymentResponse) error
+
+type NotifyOption func(*notifyOptions)
+
+type notifyOptions struct {
+	lookup      OrderLookup
+	onReject    func(ctx context.Context, resp *PaymentResponse, err error)
+	logger      Logger
+	middlewares []NotifyMiddleware
+}
+
+// WithOrderLookup compares each notification with the order it refers to
+// and rejects it when the merchant, amount or simulation flag differ. It is
+// required in platform mode, where the lookup must fill in MerchantID.
+func WithOrderLookup(lookup OrderLookup) NotifyOption {
+	return func(o *notifyOptions) {
+		o.lookup = lookup
+	}
+}
+
+func WithNotifyLogger(logger Logger) NotifyOption {
+	return func(o *notifyOptions) {
+		o.logger = logger
+	}
+}
+
+// WithRejectHandler is called for notifications that were acknowledged to
+// ECPay but not passed on to the PaymentNotifyFunc.
+func WithRejectHandler(fn func(ctx context.Context, resp *PaymentResponse, err error)) NotifyOption {
+	return func(o *notifyOptions) {
+		o.onReject = fn
+	}
+}
+
+type paymentNotifyHandler struct {
+	// config only masks secrets in logs, configFor picks the merchant.
+	config    EcpayConfig
+	configFor func(values url.Values) (EcpayConfig, error)
+	handle    PaymentNotifyFunc
+	options   notifyOptions
+}
+
+// NewPaymentNotifyHandler serves the PaymentServerReplyURL. It answers
+// "1|OK" once a notification is handled or permanently rejected, and
+// "0|<reason>" when ECPay should send it again.
+func NewPaymentNotifyHandler(config EcpayConfig, handle PaymentNotifyFunc, opts ...NotifyOption) http.Handler {
+	return newPaymentNotifyHandler(config, func(url.Values) (EcpayConfig, error) {
+		return config, nil
+	}, handle, opts)
+}
+
+func newPaymentNotifyHandler(config EcpayConfig, configFor func(values url.Values) (EcpayConfig, error), handle PaymentNotifyFunc, opts []NotifyOption) *paymentNotifyHandler {
+	h := &paymentNotifyHandler{
+		config:    config,
+		configFor: configFor,
+		handle:    handle,
+		options:   notifyOptions{logger: nopLogger{}},
+	}
+	for _, opt := range opts {
+		opt(&h.options)
+	}
+	return h
+}
+
+func (h *paymentNotifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, requestID := ensureRequestID(r.Context())
+	logger := h.options.logger
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		replyNotify(w, http.StatusBadRequest, err)
+		return
+	}
+	logger.DebugContext(ctx, "ecpay notification",
+		"request_id", requestID,
+		"body", h.config.redactBody(string(body)))
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		logger.WarnContext(ctx, "ecpay notification malformed", "request_id", requestID, "error", err)
+		replyNotify(w, http.StatusBadRequest, err)
+		return
+	}
+	n := &Notification{
+		Type:            NotificationPayment,
+		MerchantTradeNo: values.Get("MerchantTradeNo"),
+		RtnCode:         values.Get("RtnCode"),
+		Body:            string(body),
+		Values:          values,
+	}
+	err = ChainNotify(h.options.middlewares...)(h.serve)(ctx, n)
+	switch n.Outcome {
+	case NotifyHandled, NotifyRejected:
+		replyNotify(w, http.StatusOK, nil)
+	case NotifyInvalid:
+		replyNotify(w, http.StatusBadRequest, err)
+	default:
+		if err == nil {
+			err = errors.New("notification not handled")
+		}
+		replyNotify(w, http.StatusInternalServerError, err)
+	}
+}
+
+func (h *paymentNotifyHandler) serve(ctx context.Context, n *Notification) error {
+	requestID := RequestIDFromContext(ctx)
+	logger := h.options.logger
+	config, err := h.configFor(n.Values)
+	if err != nil {
+		logger.WarnContext(ctx, "ecpay notification rejected",
+			"request_id", requestID,
+			"merchant_trade_no", n.MerchantTradeNo,
+			"error", err)
+		n.Outcome = NotifyInvalid
+		return err
+	}
+	keyID, err := VerifyPaymentCheckMacValue(config, n.Values)
+	if err != nil {
+		logger.WarnContext(ctx, "ecpay notification rejected",
+			"request_id", requestID,
+			"merchant_trade_no", n.MerchantTradeNo,
+			"error", err)
+		n.Outcome = NotifyInvalid
+		return err
+	}
+	n.KeyID = keyID
+	resp, err := parsePaymentResult(n.Values)
+	if err != nil {
+		logger.WarnContext(ctx, "ecpay notification malformed",
+			"request_id", requestID,
+			"merchant_trade_no", n.MerchantTradeNo,
+			"error", err)
+		n.Outcome = NotifyInvalid
+		return err
+	}
+	resp.KeyID = keyID
+	n.Payment = resp
+
+	if err := h.verify(ctx, config, resp); err != nil {
+		if errors.Is(err, ErrNotificationMismatch) || errors.Is(err, ErrOrderNotFound) || errors.Is(err, ErrSimulatedPayment) {
+			logger.WarnContext(ctx, "ecpay notification rejected",
+				"request_id", requestID,
+				"merchant_trade_no", resp.TradeNo,
+				"error", err)
+			if h.options.onReject != nil {
+				h.options.onReject(ctx, resp, err)
+			}
+			n.Outcome = NotifyRejected
+			return err
+		}
+		logger.ErrorContext(ctx, "ecpay notification verification failed",
+			"request_id", requestID,
+			"merchant_trade_no", resp.TradeNo,
+			"error", err)
+		n.Outcome = NotifyFailed
+		return err
+	}
+
+	if err := h.handle(ctx, resp); err != nil {
+		logger.ErrorContext(ctx, "ecpay notification handler failed",
+			"request_id", requestID,
+			"merchant_trade_no", resp.TradeNo,
+			"error", err)
+		n.Outcome = NotifyFailed
+		return err
+	}
+	logger.InfoContext(ctx, "ecpay notification handled",
+		"request_id", requestID,
+		"merchant_trade_no", resp.TradeNo,
+		"rtn_code", resp.RtnCode,
+		"status", resp.Status,
+		"key_id", keyID)
+	n.Outcome = NotifyHandled
+	return nil
+}
+
+func (h *paymentNotifyHandler) verify(ctx context.Context, config EcpayConfig, resp *PaymentResponse) error {
+	if resp.Simulation && config.rejectsSimulatedPayment() {
+		return &SimulatedPaymentError{MerchantTradeNo: resp.TradeNo, IsProduction: config.IsProduction}
+	}
+	if h.options.lookup == nil {
+		// Platforms are notified of every sub-merchant's payments, only
+		// the order knows which one it belongs to.
+		if config.PlatformID != "" {
+			return ErrPlatformOrderLookup
+		}
+		if resp.MerchantID != config.MerchantID {
+			return &NotificationMismatchError{Field: "MerchantID", Expected: config.MerchantID, Actual: resp.MerchantID}
+		}
+		return nil
+	}
+	expected, err := h.options.lookup.LookupOrder(ctx, resp.TradeNo)
+	if err != nil {
+		return err
+	}
+	if expected.MerchantID == "" {
+		if config.PlatformID != "" {
+			return fmt.Errorf("%w: no MerchantID for trade %v", ErrPlatformOrderLookup, resp.TradeNo)
+		}
+		expected.MerchantID = config.MerchantID
+	}
+	if expected.MerchantTradeNo == "" {
+		expected.MerchantTradeNo = resp.TradeNo
+	}
+	return VerifyPaymentNotification(resp, expected)
+}
+
+func replyNotify(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if err != nil {
+		fmt.Fprintf(w, "0|%v", err)
+		return
+	}
+	io.WriteString(w, "1|OK")
+}
@@ -0,0 +1,175 @@
+package ecpay_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/img21326/ecpay"
+)
+
+// notification signs a paid credit card notification of 100 for trade T1,
+// fields replaces or adds values.
+func notification(config ecpay.EcpayConfig, fields map[string]string) string {
+	params := map[string]string{
+		"MerchantID":           config.MerchantID,
+		"MerchantTradeNo":      "T1",
+		"RtnCode":              "1",
+		"RtnMsg":               "交易成功",
+		"TradeNo":              "2308011200001",
+		"TradeAmt":             "100",
+		"PaymentDate":          "2023/08/01 12:00:00",
+		"PaymentType":          "Credit_CreditCard",
+		"PaymentTypeChargeFee": "3",
+		"TradeDate":            "2023/08/01 11:59:00",
+		"SimulatePaid":         "0",
+	}
+	for key, value := range fields {
+		params[key] = value
+	}
+	params["CheckMacValue"] = ecpay.NewPaymentMacValue(config).GenerateCheckMacValue(params)
+	values := url.Values{}
+	for key, value := range params {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+func postNotification(handler http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+type orderLookup map[string]ecpay.ExpectedOrder
+
+func (l orderLookup) LookupOrder(ctx context.Context, merchantTradeNo string) (ecpay.ExpectedOrder, error) {
+	order, ok := l[merchantTradeNo]
+	if !ok {
+		return ecpay.ExpectedOrder{}, ecpay.ErrOrderNotFound
+	}
+	return order, nil
+}
+
+func TestPaymentNotifyOrderLookup(t *testing.T) {
+	config := testConfig()
+	tests := []struct {
+		name    string
+		order   ecpay.ExpectedOrder
+		fields  map[string]string
+		handled bool
+		field   string
+	}{
+		{name: "match", order: ecpay.ExpectedOrder{Amount: 100}, handled: true},
+		{name: "amount", order: ecpay.ExpectedOrder{Amount: 100}, fields: map[string]string{"TradeAmt": "1"}, field: "TradeAmt"},
+		{name: "merchant", order: ecpay.ExpectedOrder{MerchantID: "2000132", Amount: 100}, field: "MerchantID"},
+		{name: "trade", order: ecpay.ExpectedOrder{MerchantTradeNo: "T2", Amount: 100}, field: "MerchantTradeNo"},
+	}
+	for _, test := range tests {
+		var handled bool
+		var rejected error
+		handler := ecpay.NewPaymentNotifyHandler(config, func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+			handled = true
+			return nil
+		},
+			ecpay.WithOrderLookup(orderLookup{"T1": test.order}),
+			ecpay.WithRejectHandler(func(ctx context.Context, resp *ecpay.PaymentResponse, err error) {
+				rejected = err
+			}))
+
+		rec := postNotification(handler, notification(config, test.fields))
+		if rec.Code != http.StatusOK || rec.Body.String() != "1|OK" {
+			t.Errorf("%v: answered %d %q, want 200 1|OK", test.name, rec.Code, rec.Body.String())
+		}
+		if handled != test.handled {
+			t.Errorf("%v: handled = %v, want %v", test.name, handled, test.handled)
+		}
+		if test.handled {
+			continue
+		}
+		var mismatch *ecpay.NotificationMismatchError
+		if !errors.As(rejected, &mismatch) || mismatch.Field != test.field {
+			t.Errorf("%v: rejected with %v, want a %v mismatch", test.name, rejected, test.field)
+		}
+	}
+}
+
+func TestPaymentNotifyUnknownOrder(t *testing.T) {
+	config := testConfig()
+	var rejected error
+	handler := ecpay.NewPaymentNotifyHandler(config, func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+		t.Error("notification of an unknown order handled")
+		return nil
+	},
+		ecpay.WithOrderLookup(orderLookup{}),
+		ecpay.WithRejectHandler(func(ctx context.Context, resp *ecpay.PaymentResponse, err error) {
+			rejected = err
+		}))
+
+	rec := postNotification(handler, notification(config, nil))
+	if rec.Body.String() != "1|OK" {
+		t.Errorf("answered %q, want 1|OK", rec.Body.String())
+	}
+	if !errors.Is(rejected, ecpay.ErrOrderNotFound) {
+		t.Errorf("rejected with %v, want ErrOrderNotFound", rejected)
+	}
+}
+
+func TestPaymentNotifyInvalidCheckMacValue(t *testing.T) {
+	config := testConfig()
+	handler := ecpay.NewPaymentNotifyHandler(config, func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+		t.Error("forged notification handled")
+		return nil
+	})
+	body := strings.Replace(notification(config, nil), "TradeAmt=100", "TradeAmt=1", 1)
+	if rec := postNotification(handler, body); rec.Code != http.StatusBadRequest {
+		t.Errorf("answered %d, want 400", rec.Code)
+	}
+}
+
+func TestPaymentNotifySimulatedPayment(t *testing.T) {
+	tests := []struct {
+		name       string
+		production bool
+		policy     ecpay.SimulatedPaymentPolicy
+		handled    bool
+	}{
+		{"production", true, ecpay.SimulatedPaymentAuto, false},
+		{"staging", false, ecpay.SimulatedPaymentAuto, true},
+		{"reject", false, ecpay.SimulatedPaymentReject, false},
+		{"flag", true, ecpay.SimulatedPaymentFlag, true},
+	}
+	for _, test := range tests {
+		config := testConfig()
+		config.IsProduction = test.production
+		config.SimulatedPayment = test.policy
+		var handled *ecpay.PaymentResponse
+		var rejected error
+		handler := ecpay.NewPaymentNotifyHandler(config, func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+			handled = resp
+			return nil
+		}, ecpay.WithRejectHandler(func(ctx context.Context, resp *ecpay.PaymentResponse, err error) {
+			rejected = err
+		}))
+
+		rec := postNotification(handler, notification(config, map[string]string{"SimulatePaid": "1"}))
+		if rec.Body.String() != "1|OK" {
+			t.Errorf("%v: answered %q, want 1|OK", test.name, rec.Body.String())
+		}
+		if (handled != nil) != test.handled {
+			t.Errorf("%v: handled = %v, want %v", test.name, handled != nil, test.handled)
+		}
+		if handled != nil && !handled.Simulation {
+			t.Errorf("%v: Simulation not set", test.name)
+		}
+		if !test.handled && !errors.Is(rejected, ecpay.ErrSimulatedPayment) {
+			t.Errorf("%v: rejected with %v, want ErrSimulatedPayment", test.name, rejected)
+		}
+	}
+}
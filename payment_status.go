@@ -0,0 +1,142 @@
+package ecpay
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentStatusPending               PaymentStatus = "PENDING"
+	PaymentStatusWaitingOfflinePayment PaymentStatus = "WAITING_OFFLINE_PAYMENT"
+	PaymentStatusPaid                  PaymentStatus = "PAID"
+	PaymentStatusFailed                PaymentStatus = "FAILED"
+	PaymentStatusRefunded              PaymentStatus = "REFUNDED"
+	PaymentStatusPartiallyRefunded     PaymentStatus = "PARTIALLY_REFUNDED"
+	PaymentStatusCancelled             PaymentStatus = "CANCELLED"
+)
+
+var ErrInvalidPaymentTransition = errors.New("invalid payment status transition")
+
+// paymentTransitions lists the statuses each status may move to. Repeating
+// the current status is always allowed because ECPay resends notifications.
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentStatusPending: {
+		PaymentStatusWaitingOfflinePayment,
+		PaymentStatusPaid,
+		PaymentStatusFailed,
+		PaymentStatusCancelled,
+	},
+	PaymentStatusWaitingOfflinePayment: {
+		PaymentStatusPaid,
+		PaymentStatusFailed,
+		PaymentStatusCancelled,
+	},
+	PaymentStatusPaid: {
+		PaymentStatusRefunded,
+		PaymentStatusPartiallyRefunded,
+		PaymentStatusCancelled,
+	},
+	PaymentStatusPartiallyRefunded: {
+		PaymentStatusRefunded,
+	},
+	PaymentStatusFailed:    {},
+	PaymentStatusRefunded:  {},
+	PaymentStatusCancelled: {},
+}
+
+func (s PaymentStatus) IsFinal() bool {
+	next, ok := paymentTransitions[s]
+	return ok && len(next) == 0
+}
+
+func (s PaymentStatus) CanTransitionTo(next PaymentStatus) bool {
+	if _, ok := paymentTransitions[next]; !ok {
+		return false
+	}
+	if s == next {
+		return true
+	}
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+func (s PaymentStatus) TransitionTo(next PaymentStatus) error {
+	if !s.CanTransitionTo(next) {
+		return fmt.Errorf("%w: %v -> %v", ErrInvalidPaymentTransition, s, next)
+	}
+	return nil
+}
+
+var offlinePaymentTypes = []string{"ATM", "CVS", "BARCODE"}
+
+func isOfflinePaymentType(paymentType string) bool {
+	for _, prefix := range offlinePaymentTypes {
+		if strings.HasPrefix(paymentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// PaymentStatusFromNotify maps the RtnCode of a ReturnURL or PaymentInfoURL
+// notification. ATM returns 2 and CVS/BARCODE return 10100073 once a payment
+// number has been issued.
+func PaymentStatusFromNotify(rtnCode string) PaymentStatus {
+	switch rtnCode {
+	case "1":
+		return PaymentStatusPaid
+	case "2", "10100073":
+		return PaymentStatusWaitingOfflinePayment
+	default:
+		return PaymentStatusFailed
+	}
+}
+
+// isQueryTradeStatus tells trade states apart from the error codes
+// QueryTradeInfo/V5 also answers with in TradeStatus.
+func isQueryTradeStatus(tradeStatus string) bool {
+	switch tradeStatus {
+	case "0", "1", "10200095":
+		return true
+	}
+	return false
+}
+
+// PaymentStatusFromQuery maps the TradeStatus of QueryTradeInfo/V5.
+func PaymentStatusFromQuery(tradeStatus string, paymentType string) PaymentStatus {
+	switch tradeStatus {
+	case "1":
+		return PaymentStatusPaid
+	case "0":
+		if isOfflinePaymentType(paymentType) {
+			return PaymentStatusWaitingOfflinePayment
+		}
+		return PaymentStatusPending
+	case "10200095":
+		return PaymentStatusFailed
+	default:
+		return PaymentStatusPending
+	}
+}
+
+// PaymentStatusFromRefund maps the DoAction that was executed against a
+// credit card trade. Action "R" refunds a closed trade, any other action
+// releases the authorization and cancels the trade. closableAmount is what
+// was left to refund before this refund, the clsamt of
+// CreditDetail/QueryTrade/V2.
+func PaymentStatusFromRefund(action string, refundAmount Money, closableAmount Money) PaymentStatus {
+	if action != "R" {
+		return PaymentStatusCancelled
+	}
+	if closableAmount > 0 && refundAmount < closableAmount {
+		return PaymentStatusPartiallyRefunded
+	}
+	return PaymentStatusRefunded
+}
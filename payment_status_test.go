@@ -0,0 +1,106 @@
+package ecpay_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+func TestPaymentStatusFromRefund(t *testing.T) {
+	tests := []struct {
+		action   string
+		refund   ecpay.Money
+		closable ecpay.Money
+		want     ecpay.PaymentStatus
+	}{
+		{"R", 50, 150, ecpay.PaymentStatusPartiallyRefunded},
+		{"R", 150, 150, ecpay.PaymentStatusRefunded},
+		{"R", 40, 40, ecpay.PaymentStatusRefunded},
+		{"N", 150, 150, ecpay.PaymentStatusCancelled},
+	}
+	for _, test := range tests {
+		if got := ecpay.PaymentStatusFromRefund(test.action, test.refund, test.closable); got != test.want {
+			t.Errorf("PaymentStatusFromRefund(%v, %v, %v) = %v, want %v", test.action, test.refund, test.closable, got, test.want)
+		}
+	}
+}
+
+func TestPartialRefunds(t *testing.T) {
+	config := testConfig()
+	var notified <-chan *ecpay.PaymentResponse
+	config.PaymentServerReplyURL, notified = notifyServer(t, config)
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+	ec := srv.Client()
+
+	paid := pay(t, srv, ec, "T1", 150, notified)
+	if err := srv.SetCreditStatus("T1", ecpaytest.CreditClosed); err != nil {
+		t.Fatalf("SetCreditStatus: %v", err)
+	}
+	query, err := ec.QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "T1"})
+	if err != nil {
+		t.Fatalf("QueryPayment: %v", err)
+	}
+
+	refunds := []struct {
+		amount ecpay.Money
+		want   ecpay.PaymentStatus
+	}{
+		{50, ecpay.PaymentStatusPartiallyRefunded},
+		{60, ecpay.PaymentStatusPartiallyRefunded},
+		{40, ecpay.PaymentStatusRefunded},
+	}
+	for _, refund := range refunds {
+		resp, err := ec.RefundPayment(ecpay.RefundConfig{
+			MerchantTradeNo:   "T1",
+			BankTransactionID: query.BankTransactionID,
+			RefundID:          paid.RefundID,
+			Amount:            refund.amount,
+		})
+		if err != nil {
+			t.Fatalf("refund %v: %v", refund.amount, err)
+		}
+		if resp.Status != refund.want {
+			t.Errorf("refund %v status = %v, want %v", refund.amount, resp.Status, refund.want)
+		}
+	}
+
+	_, err = ec.RefundPayment(ecpay.RefundConfig{
+		MerchantTradeNo:   "T1",
+		BankTransactionID: query.BankTransactionID,
+		RefundID:          paid.RefundID,
+		Amount:            1,
+	})
+	if err == nil {
+		t.Error("refund beyond the trade amount succeeded")
+	}
+}
+
+func TestRefundMalformedClosableAmount(t *testing.T) {
+	for _, clsamt := range []string{``, `,"clsamt":"150"`, `,"clsamt":99.5`} {
+		var doActions int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/CreditDetail/DoAction" {
+				atomic.AddInt32(&doActions, 1)
+				fmt.Fprint(w, "RtnCode=1&RtnMsg=OK")
+				return
+			}
+			fmt.Fprintf(w, `{"RtnMsg":"","RtnValue":{"status":"已關帳","amount":150%s}}`, clsamt)
+		}))
+		ec := ecpay.NewEcpay(testConfig(), ecpay.WithPaymentBaseURL(srv.URL))
+		_, err := ec.RefundPayment(ecpay.RefundConfig{MerchantTradeNo: "T1", BankTransactionID: "1", RefundID: "2", Amount: 50})
+		srv.Close()
+		if !errors.Is(err, ecpay.ErrUnexpectedResponse) {
+			t.Errorf("clsamt %q: RefundPayment error = %v, want ErrUnexpectedResponse", clsamt, err)
+		}
+		if n := atomic.LoadInt32(&doActions); n != 0 {
+			t.Errorf("clsamt %q: %d DoAction requests sent", clsamt, n)
+		}
+	}
+}
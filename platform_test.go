@@ -0,0 +1,92 @@
+package ecpay_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/img21326/ecpay"
+)
+
+func platformConfig() ecpay.EcpayConfig {
+	config := testConfig()
+	config.PlatformID = "3002599"
+	return config
+}
+
+func TestPlatformRequestParams(t *testing.T) {
+	config := platformConfig()
+	forms := make(chan url.Values, 1)
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms <- r.PostForm
+		io.WriteString(w, "TradeStatus=0")
+	})
+	ec := ecpay.NewEcpay(config, ecpay.WithPaymentBaseURL(api.URL))
+
+	ec.QueryPayment(ecpay.QueryConfig{MerchantID: "2000132", MerchantTradeNo: "T1"})
+	form := <-forms
+	if form.Get("PlatformID") != config.PlatformID || form.Get("MerchantID") != "2000132" {
+		t.Errorf("PlatformID, MerchantID = %q, %q, want %q, 2000132", form.Get("PlatformID"), form.Get("MerchantID"), config.PlatformID)
+	}
+	params := make(map[string]string)
+	for key := range form {
+		if key != "CheckMacValue" {
+			params[key] = form.Get(key)
+		}
+	}
+	if want := ecpay.NewPaymentMacValue(config).GenerateCheckMacValue(params); form.Get("CheckMacValue") != want {
+		t.Errorf("CheckMacValue = %q, want %q signed with the platform's keys", form.Get("CheckMacValue"), want)
+	}
+
+	ec.QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "T2"})
+	if form := <-forms; form.Get("MerchantID") != config.MerchantID {
+		t.Errorf("default MerchantID = %q, want %q", form.Get("MerchantID"), config.MerchantID)
+	}
+}
+
+func TestRegistryPlatformNotify(t *testing.T) {
+	config := platformConfig()
+	registry := ecpay.NewRegistry()
+	if _, err := registry.Register(config); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	// Sub-merchants are not registered, ECPay signs with the platform's keys.
+	sub := config
+	sub.MerchantID = "2000132"
+	body := notification(sub, map[string]string{"PlatformID": config.PlatformID})
+
+	tests := []struct {
+		name    string
+		order   ecpay.ExpectedOrder
+		code    int
+		handled bool
+	}{
+		{"sub-merchant order", ecpay.ExpectedOrder{MerchantID: "2000132", Amount: 100}, http.StatusOK, true},
+		{"no MerchantID", ecpay.ExpectedOrder{Amount: 100}, http.StatusInternalServerError, false},
+	}
+	for _, test := range tests {
+		handled := false
+		handler := registry.NewPaymentNotifyHandler(func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+			handled = true
+			return nil
+		}, ecpay.WithOrderLookup(orderLookup{"T1": test.order}))
+
+		rec := postNotification(handler, body)
+		if rec.Code != test.code || handled != test.handled {
+			t.Errorf("%v: status %d, handled %v, want %d, %v", test.name, rec.Code, handled, test.code, test.handled)
+		}
+	}
+
+	unknown := sub
+	unknown.PlatformID = "9999999"
+	handler := registry.NewPaymentNotifyHandler(func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+		t.Error("notification of unknown platform handled")
+		return nil
+	})
+	if rec := postNotification(handler, notification(unknown, map[string]string{"PlatformID": unknown.PlatformID})); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown platform: status %d, want 400", rec.Code)
+	}
+}
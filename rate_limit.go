@@ -0,0 +1,129 @@
+package ecpay
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket allowing PerSecond requests on average and
+// bursts of up to Burst requests. A zero PerSecond means no limit.
+type RateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+// RateLimits holds one bucket per endpoint family.
+type RateLimits struct {
+	// PaymentQuery covers Cashier/QueryTradeInfo/V5 and
+	// CreditDetail/QueryTrade/V2.
+	PaymentQuery RateLimit
+	// PaymentAction covers CreditDetail/DoAction.
+	PaymentAction RateLimit
+	// LogisticsQuery covers Helper/QueryLogisticsTradeInfo/V4.
+	LogisticsQuery RateLimit
+	// Logistics covers Express/Create.
+	Logistics RateLimit
+}
+
+type endpointFamily int
+
+const (
+	familyPaymentQuery endpointFamily = iota
+	familyPaymentAction
+	familyLogisticsQuery
+	familyLogistics
+)
+
+var endpointFamilies = map[string]endpointFamily{
+	"Cashier/QueryTradeInfo/V5":         familyPaymentQuery,
+	"CreditDetail/QueryTrade/V2":        familyPaymentQuery,
+	"CreditDetail/DoAction":             familyPaymentAction,
+	"Helper/QueryLogisticsTradeInfo/V4": familyLogisticsQuery,
+	"Express/Create":                    familyLogistics,
+}
+
+func (l RateLimits) buckets() map[endpointFamily]*tokenBucket {
+	buckets := make(map[endpointFamily]*tokenBucket)
+	for family, limit := range map[endpointFamily]RateLimit{
+		familyPaymentQuery:   l.PaymentQuery,
+		familyPaymentAction:  l.PaymentAction,
+		familyLogisticsQuery: l.LogisticsQuery,
+		familyLogistics:      l.Logistics,
+	} {
+		if limit.PerSecond > 0 {
+			buckets[family] = newTokenBucket(limit)
+		}
+	}
+	return buckets
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   limit.PerSecond,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available or ctx is done.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// acquire waits for the endpoint's rate limit and a free request slot. The
+// returned func releases the slot.
+func (e *EcpayImpl) acquire(ctx context.Context, endpoint string) (func(), error) {
+	if family, ok := endpointFamilies[endpoint]; ok {
+		if bucket, ok := e.limiters[family]; ok {
+			if err := bucket.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if e.inFlight == nil {
+		return func() {}, nil
+	}
+	select {
+	case e.inFlight <- struct{}{}:
+		return func() { <-e.inFlight }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
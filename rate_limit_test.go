@@ -0,0 +1,77 @@
+package ecpay_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+func TestRateLimit(t *testing.T) {
+	config := testConfig()
+	config.RateLimits.LogisticsQuery = ecpay.RateLimit{PerSecond: 20, Burst: 1}
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+	ec := srv.Client()
+	if _, err := ec.CreateShipOrder(shipOrderConfig("S1")); err != nil {
+		t.Fatalf("CreateShipOrder: %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := ec.QueryShip(ecpay.QueryShipConfig{MerchantTradeNo: "S1"}); err != nil {
+			t.Fatalf("QueryShip: %v", err)
+		}
+	}
+	// The burst covers the first query, the other two wait 50ms each.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("3 queries took %v, want about 100ms at 20 per second", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ec.QueryShipContext(ctx, ecpay.QueryShipConfig{MerchantTradeNo: "S1"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("QueryShipContext error = %v, want context.Canceled", err)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	var inFlight, maxInFlight int32
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	config := testConfig()
+	config.MaxInFlight = 2
+	ec := ecpay.NewEcpay(config, ecpay.WithPaymentBaseURL(api.URL))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ec.QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "T1"})
+		}()
+	}
+	wg.Wait()
+	if max := atomic.LoadInt32(&maxInFlight); max > 2 {
+		t.Errorf("%d requests in flight, want at most 2", max)
+	}
+	if n := api.count("/Cashier/QueryTradeInfo/V5"); n != 6 {
+		t.Errorf("%d requests, want 6", n)
+	}
+}
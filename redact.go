@@ -0,0 +1,115 @@
+package ecpay
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// EcpayConfig and PaymentResponse mask their secrets and card data when
+// printed or marshaled to JSON. Wrap them with Reveal to see everything.
+
+type plainConfig EcpayConfig
+
+type plainPaymentResponse PaymentResponse
+
+func redactField(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+func (c EcpayConfig) redacted() plainConfig {
+	c.HashKey = redactField(c.HashKey)
+	c.HashIV = redactField(c.HashIV)
+	c.CreditCheckKey = redactField(c.CreditCheckKey)
+	if c.AdditionalKeys != nil {
+		keys := make([]KeyPair, len(c.AdditionalKeys))
+		for i, key := range c.AdditionalKeys {
+			keys[i] = KeyPair{ID: key.ID, HashKey: redactField(key.HashKey), HashIV: redactField(key.HashIV)}
+		}
+		c.AdditionalKeys = keys
+	}
+	return plainConfig(c)
+}
+
+func (c EcpayConfig) String() string {
+	return fmt.Sprintf("%+v", c.redacted())
+}
+
+func (c EcpayConfig) GoString() string {
+	return goString(c.redacted(), "plainConfig", "EcpayConfig")
+}
+
+func (c EcpayConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.redacted())
+}
+
+func (p PaymentResponse) redacted() plainPaymentResponse {
+	p.WebATMAccBank = redactField(p.WebATMAccBank)
+	p.WebATMAccNo = redactField(p.WebATMAccNo)
+	p.Card4No = redactField(p.Card4No)
+	p.AuthCode = redactField(p.AuthCode)
+	return plainPaymentResponse(p)
+}
+
+func (p PaymentResponse) String() string {
+	return fmt.Sprintf("%+v", p.redacted())
+}
+
+func (p PaymentResponse) GoString() string {
+	return goString(p.redacted(), "plainPaymentResponse", "PaymentResponse")
+}
+
+func (p PaymentResponse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.redacted())
+}
+
+// goString prints v with %#v under the name of the type it stands for.
+func goString(v interface{}, plainName string, name string) string {
+	return strings.Replace(fmt.Sprintf("%#v", v), "ecpay."+plainName, "ecpay."+name, 1)
+}
+
+// Revealed prints and marshals the value it wraps without masking.
+type Revealed struct {
+	v interface{}
+}
+
+// Reveal opts in to printing secrets, e.g.
+// fmt.Printf("%+v", ecpay.Reveal(resp)). Values of other types are
+// printed as they are.
+func Reveal(v interface{}) Revealed {
+	return Revealed{v: v}
+}
+
+func (r Revealed) plain() interface{} {
+	switch v := r.v.(type) {
+	case EcpayConfig:
+		return plainConfig(v)
+	case *EcpayConfig:
+		if v != nil {
+			return (*plainConfig)(v)
+		}
+	case PaymentResponse:
+		return plainPaymentResponse(v)
+	case *PaymentResponse:
+		if v != nil {
+			return (*plainPaymentResponse)(v)
+		}
+	}
+	return r.v
+}
+
+func (r Revealed) Format(f fmt.State, verb rune) {
+	s := fmt.Sprintf(fmt.FormatString(f, verb), r.plain())
+	if f.Flag('#') {
+		s = strings.Replace(s, "ecpay.plainConfig", "ecpay.EcpayConfig", 1)
+		s = strings.Replace(s, "ecpay.plainPaymentResponse", "ecpay.PaymentResponse", 1)
+	}
+	fmt.Fprint(f, s)
+}
+
+func (r Revealed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.plain())
+}
@@ -0,0 +1,17 @@
+//go:build go1.21
+
+package ecpay
+
+import "log/slog"
+
+func (c EcpayConfig) LogValue() slog.Value {
+	return slog.AnyValue(c.redacted())
+}
+
+func (p PaymentResponse) LogValue() slog.Value {
+	return slog.AnyValue(p.redacted())
+}
+
+func (r Revealed) LogValue() slog.Value {
+	return slog.AnyValue(r.plain())
+}
@@ -0,0 +1,86 @@
+package ecpay_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/img21326/ecpay"
+)
+
+func TestConfigRedacted(t *testing.T) {
+	config := testConfig()
+	config.AdditionalKeys = []ecpay.KeyPair{{ID: "previous", HashKey: "5294y06JbISpM5x9", HashIV: "v77hoKGq4kWxNNIS"}}
+	secrets := []string{config.HashKey, config.HashIV, config.CreditCheckKey, "5294y06JbISpM5x9", "v77hoKGq4kWxNNIS"}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	for name, printed := range map[string]string{
+		"%v":   fmt.Sprintf("%v", config),
+		"%+v":  fmt.Sprintf("%+v", config),
+		"%#v":  fmt.Sprintf("%#v", config),
+		"*%v":  fmt.Sprintf("%v", &config),
+		"json": string(data),
+	} {
+		for _, secret := range secrets {
+			if strings.Contains(printed, secret) {
+				t.Errorf("%s contains %q: %s", name, secret, printed)
+			}
+		}
+		if !strings.Contains(printed, config.MerchantID) || !strings.Contains(printed, "previous") {
+			t.Errorf("%s lost MerchantID or key ID: %s", name, printed)
+		}
+	}
+	if s := fmt.Sprintf("%#v", config); !strings.HasPrefix(s, "ecpay.EcpayConfig{") {
+		t.Errorf("%%#v = %s, want ecpay.EcpayConfig{...}", s)
+	}
+
+	revealed := fmt.Sprintf("%+v", ecpay.Reveal(config))
+	for _, secret := range secrets {
+		if !strings.Contains(revealed, secret) {
+			t.Errorf("Reveal hides %q: %s", secret, revealed)
+		}
+	}
+}
+
+func TestPaymentResponseRedacted(t *testing.T) {
+	resp := &ecpay.PaymentResponse{
+		TradeNo:     "T1",
+		Card4No:     "4321",
+		AuthCode:    "777777",
+		WebATMAccNo: "12345",
+	}
+	secrets := []string{"4321", "777777", "12345"}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	for name, printed := range map[string]string{
+		"%+v":  fmt.Sprintf("%+v", resp),
+		"%#v":  fmt.Sprintf("%#v", *resp),
+		"json": string(data),
+	} {
+		for _, secret := range secrets {
+			if strings.Contains(printed, secret) {
+				t.Errorf("%s contains %q: %s", name, secret, printed)
+			}
+		}
+		if !strings.Contains(printed, "T1") {
+			t.Errorf("%s lost TradeNo: %s", name, printed)
+		}
+	}
+
+	data, err = json.Marshal(ecpay.Reveal(resp))
+	if err != nil {
+		t.Fatalf("Marshal revealed: %v", err)
+	}
+	for _, secret := range secrets {
+		if !strings.Contains(string(data), secret) {
+			t.Errorf("Reveal hides %q: %s", secret, data)
+		}
+	}
+}
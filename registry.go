@@ -0,0 +1,143 @@
+package ecpay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+var ErrUnknownMerchant = errors.New("unknown merchant")
+
+type registeredMerchant struct {
+	config EcpayConfig
+	client EcpayContext
+}
+
+// Registry holds one client per MerchantID for services running several
+// brands. It is safe for concurrent use.
+type Registry struct {
+	opts []Option
+
+	mu        sync.RWMutex
+	merchants map[string]registeredMerchant
+	// platforms maps PlatformID to the MerchantID registered with it.
+	platforms map[string]string
+}
+
+// NewRegistry applies opts to every client it creates.
+func NewRegistry(opts ...Option) *Registry {
+	return &Registry{
+		opts:      opts,
+		merchants: make(map[string]registeredMerchant),
+		platforms: make(map[string]string),
+	}
+}
+
+// Register creates the client of config.MerchantID, opts are applied after
+// those of the registry.
+func (r *Registry) Register(config EcpayConfig, opts ...Option) (EcpayContext, error) {
+	if config.MerchantID == "" {
+		return nil, errors.New("register merchant: empty MerchantID")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.merchants[config.MerchantID]; ok {
+		return nil, fmt.Errorf("register merchant: %v already registered", config.MerchantID)
+	}
+	if _, ok := r.platforms[config.PlatformID]; ok && config.PlatformID != "" {
+		return nil, fmt.Errorf("register merchant: platform %v already registered", config.PlatformID)
+	}
+	allOpts := append(append([]Option{}, r.opts...), opts...)
+	client := NewEcpay(config, allOpts...)
+	r.merchants[config.MerchantID] = registeredMerchant{config: config, client: client}
+	if config.PlatformID != "" {
+		r.platforms[config.PlatformID] = config.MerchantID
+	}
+	return client, nil
+}
+
+func (r *Registry) Unregister(merchantID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.merchants[merchantID]; ok && m.config.PlatformID != "" {
+		delete(r.platforms, m.config.PlatformID)
+	}
+	delete(r.merchants, merchantID)
+}
+
+func (r *Registry) lookup(merchantID string) (registeredMerchant, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.merchants[merchantID]
+	if !ok {
+		return registeredMerchant{}, fmt.Errorf("%w: %q", ErrUnknownMerchant, merchantID)
+	}
+	return m, nil
+}
+
+// Client returns the client calling ECPay as merchantID.
+func (r *Registry) Client(merchantID string) (EcpayContext, error) {
+	m, err := r.lookup(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	return m.client, nil
+}
+
+// Platform returns the client registered with platformID, which calls
+// ECPay for all of the platform's sub-merchants.
+func (r *Registry) Platform(platformID string) (EcpayContext, error) {
+	r.mu.RLock()
+	merchantID, ok := r.platforms[platformID]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: platform %q", ErrUnknownMerchant, platformID)
+	}
+	return r.Client(merchantID)
+}
+
+// configFor picks the config of a callback: the platform's when it carries
+// a PlatformID, since sub-merchants are not registered themselves.
+func (r *Registry) configFor(values url.Values) (EcpayConfig, error) {
+	if platformID := values.Get("PlatformID"); platformID != "" {
+		r.mu.RLock()
+		merchantID, ok := r.platforms[platformID]
+		r.mu.RUnlock()
+		if !ok {
+			return EcpayConfig{}, fmt.Errorf("%w: platform %q", ErrUnknownMerchant, platformID)
+		}
+		return r.Config(merchantID)
+	}
+	return r.Config(values.Get("MerchantID"))
+}
+
+func (r *Registry) Config(merchantID string) (EcpayConfig, error) {
+	m, err := r.lookup(merchantID)
+	if err != nil {
+		return EcpayConfig{}, err
+	}
+	return m.config, nil
+}
+
+func (r *Registry) MerchantIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := make([]string, 0, len(r.merchants))
+	for id := range r.merchants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// NewPaymentNotifyHandler serves the PaymentServerReplyURL of every
+// registered merchant. The PlatformID of each notification, or its
+// MerchantID outside platform mode, picks the keys its CheckMacValue is
+// verified with; unknown merchants are answered with 400 and counted as
+// NotifyInvalid.
+func (r *Registry) NewPaymentNotifyHandler(handle PaymentNotifyFunc, opts ...NotifyOption) http.Handler {
+	return newPaymentNotifyHandler(EcpayConfig{}, r.configFor, handle, opts)
+}
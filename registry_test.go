@@ -0,0 +1,73 @@
+package ecpay_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/img21326/ecpay"
+)
+
+func TestRegistryNotifyRoutesByMerchant(t *testing.T) {
+	first := testConfig()
+	second := testConfig()
+	second.MerchantID = "2000132"
+	second.HashKey = "5294y06JbISpM5x9"
+	second.HashIV = "v77hoKGq4kWxNNIS"
+	registry := ecpay.NewRegistry()
+	for _, config := range []ecpay.EcpayConfig{first, second} {
+		if _, err := registry.Register(config); err != nil {
+			t.Fatalf("Register %v: %v", config.MerchantID, err)
+		}
+	}
+	var handled []string
+	handler := registry.NewPaymentNotifyHandler(func(ctx context.Context, resp *ecpay.PaymentResponse) error {
+		handled = append(handled, resp.MerchantID)
+		return nil
+	})
+
+	for _, config := range []ecpay.EcpayConfig{first, second} {
+		if rec := postNotification(handler, notification(config, nil)); rec.Code != http.StatusOK || rec.Body.String() != "1|OK" {
+			t.Errorf("notification of %v = %d %q, want 200 1|OK", config.MerchantID, rec.Code, rec.Body.String())
+		}
+	}
+	// Signed with the keys of the first merchant but claiming the second.
+	forged := first
+	forged.MerchantID = second.MerchantID
+	if rec := postNotification(handler, notification(forged, nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("notification with other merchant's keys = %d, want 400", rec.Code)
+	}
+	unknown := testConfig()
+	unknown.MerchantID = "9999999"
+	if rec := postNotification(handler, notification(unknown, nil)); rec.Code != http.StatusBadRequest {
+		t.Errorf("notification of unknown merchant = %d, want 400", rec.Code)
+	}
+	if len(handled) != 2 || handled[0] != first.MerchantID || handled[1] != second.MerchantID {
+		t.Errorf("handled = %v, want [%v %v]", handled, first.MerchantID, second.MerchantID)
+	}
+}
+
+func TestRegistryMerchants(t *testing.T) {
+	registry := ecpay.NewRegistry()
+	if _, err := registry.Register(testConfig()); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if _, err := registry.Register(testConfig()); err == nil {
+		t.Error("registering a MerchantID twice succeeded")
+	}
+	if _, err := registry.Client(testConfig().MerchantID); err != nil {
+		t.Errorf("Client: %v", err)
+	}
+	if _, err := registry.Client("9999999"); !errors.Is(err, ecpay.ErrUnknownMerchant) {
+		t.Errorf("Client of unknown merchant error = %v, want ErrUnknownMerchant", err)
+	}
+
+	registry.Unregister(testConfig().MerchantID)
+	if _, err := registry.Client(testConfig().MerchantID); !errors.Is(err, ecpay.ErrUnknownMerchant) {
+		t.Errorf("Client after Unregister error = %v, want ErrUnknownMerchant", err)
+	}
+	if ids := registry.MerchantIDs(); len(ids) != 0 {
+		t.Errorf("MerchantIDs = %v, want none", ids)
+	}
+}
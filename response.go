@@ -0,0 +1,84 @@
+package ecpay
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type ResponseKind string
+
+const (
+	ResponseEmpty      ResponseKind = "empty"
+	ResponseHTML       ResponseKind = "html"
+	ResponseHTTPStatus ResponseKind = "http_status"
+	ResponseMalformed  ResponseKind = "malformed"
+)
+
+const maxErrorBodyLength = 512
+
+var ErrUnexpectedResponse = errors.New("unexpected response")
+
+// ResponseError is returned when ECPay answers with something other than
+// the documented format, e.g. a maintenance page. Body is truncated.
+type ResponseError struct {
+	Endpoint   string
+	StatusCode int
+	Kind       ResponseKind
+	Body       string
+	Err        error
+}
+
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("ecpay %v: %v: %v (status %d)", e.Endpoint, ErrUnexpectedResponse, e.Kind, e.StatusCode)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Body != "" {
+		msg += fmt.Sprintf(", body: %q", e.Body)
+	}
+	return msg
+}
+
+func (e *ResponseError) Is(target error) bool {
+	return target == ErrUnexpectedResponse
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+func truncateBody(body string) string {
+	if len(body) <= maxErrorBodyLength {
+		return body
+	}
+	cut := maxErrorBodyLength
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return body[:cut] + "..."
+}
+
+// isHTML catches maintenance and error pages, no ECPay API answers with
+// markup.
+func isHTML(body string) bool {
+	return strings.HasPrefix(strings.TrimSpace(body), "<")
+}
+
+// classifyResponse rejects responses no endpoint can parse.
+func classifyResponse(endpoint string, statusCode int, body string) error {
+	switch {
+	case statusCode < 200 || statusCode > 299:
+		return &ResponseError{Endpoint: endpoint, StatusCode: statusCode, Kind: ResponseHTTPStatus, Body: truncateBody(body)}
+	case strings.TrimSpace(body) == "":
+		return &ResponseError{Endpoint: endpoint, StatusCode: statusCode, Kind: ResponseEmpty}
+	case isHTML(body):
+		return &ResponseError{Endpoint: endpoint, StatusCode: statusCode, Kind: ResponseHTML, Body: truncateBody(body)}
+	}
+	return nil
+}
+
+func malformedResponse(endpoint string, body string, err error) error {
+	return &ResponseError{Endpoint: endpoint, StatusCode: 200, Kind: ResponseMalformed, Body: truncateBody(body), Err: err}
+}
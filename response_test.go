@@ -0,0 +1,116 @@
+package ecpay_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/img21326/ecpay"
+)
+
+// fakeAPI answers every request with answer and counts requests per path.
+type fakeAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newFakeAPI(t *testing.T, answer func(w http.ResponseWriter, r *http.Request)) *fakeAPI {
+	t.Helper()
+	api := &fakeAPI{requests: make(map[string]int)}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		api.requests[r.URL.Path]++
+		api.mu.Unlock()
+		answer(w, r)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+func (api *fakeAPI) count(path string) int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.requests[path]
+}
+
+func (api *fakeAPI) client(opts ...ecpay.Option) ecpay.EcpayContext {
+	opts = append([]ecpay.Option{ecpay.WithPaymentBaseURL(api.URL), ecpay.WithLogisticsBaseURL(api.URL)}, opts...)
+	return ecpay.NewEcpay(testConfig(), opts...)
+}
+
+func TestNonStandardResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		kind   ecpay.ResponseKind
+	}{
+		{"maintenance page", http.StatusOK, "<!DOCTYPE html><html><body>系統維護中</body></html>", ecpay.ResponseHTML},
+		{"empty", http.StatusOK, " \n", ecpay.ResponseEmpty},
+		{"bad gateway", http.StatusBadGateway, "Bad Gateway", ecpay.ResponseHTTPStatus},
+	}
+	for _, test := range tests {
+		api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			io.WriteString(w, test.body)
+		})
+		ec := api.client()
+
+		_, err := ec.QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "T1"})
+		var respErr *ecpay.ResponseError
+		if !errors.As(err, &respErr) || respErr.Kind != test.kind {
+			t.Errorf("%v: QueryPayment error = %v, want a %v ResponseError", test.name, err, test.kind)
+		}
+		if !errors.Is(err, ecpay.ErrUnexpectedResponse) {
+			t.Errorf("%v: QueryPayment error = %v, want ErrUnexpectedResponse", test.name, err)
+		}
+		_, err = ec.QueryShip(ecpay.QueryShipConfig{MerchantTradeNo: "S1"})
+		if !errors.As(err, &respErr) || respErr.Kind != test.kind {
+			t.Errorf("%v: QueryShip error = %v, want a %v ResponseError", test.name, err, test.kind)
+		}
+	}
+}
+
+func TestCreateShipOrderWithoutSeparator(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "MerchantTradeNo=S1&RtnCode=300")
+	})
+	_, err := api.client().CreateShipOrder(shipOrderConfig("S1"))
+	var respErr *ecpay.ResponseError
+	if !errors.As(err, &respErr) || respErr.Kind != ecpay.ResponseMalformed {
+		t.Errorf("CreateShipOrder error = %v, want a malformed ResponseError", err)
+	}
+}
+
+func TestRefundUnexpectedCreditStatus(t *testing.T) {
+	tests := []struct {
+		name  string
+		trade string
+		want  error
+	}{
+		{"empty RtnValue", `{"RtnValue":{}}`, ecpay.ErrUnexpectedResponse},
+		{"numeric status", `{"RtnValue":{"status":1,"clsamt":100}}`, ecpay.ErrUnexpectedResponse},
+		{"cancelled", `{"RtnValue":{"status":"已取消","clsamt":0}}`, ecpay.ErrRefundNotAllowed},
+	}
+	for _, test := range tests {
+		api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/CreditDetail/DoAction" {
+				io.WriteString(w, "RtnCode=1&RtnMsg=OK")
+				return
+			}
+			io.WriteString(w, test.trade)
+		})
+		_, err := api.client().RefundPayment(ecpay.RefundConfig{MerchantTradeNo: "T1", BankTransactionID: "1", RefundID: "2", Amount: 100})
+		if !errors.Is(err, test.want) {
+			t.Errorf("%v: RefundPayment error = %v, want %v", test.name, err, test.want)
+		}
+		if n := api.count("/CreditDetail/DoAction"); n != 0 {
+			t.Errorf("%v: %d DoAction requests sent", test.name, n)
+		}
+	}
+}
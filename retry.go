@@ -0,0 +1,129 @@
+package ecpay
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+type RetryClass int
+
+const (
+	RetryNetworkErrors RetryClass = 1 << iota
+	RetryServerErrors
+	RetryThrottled
+	// RetryMaintenance retries HTML and empty answers, which ECPay serves
+	// during maintenance windows.
+	RetryMaintenance
+)
+
+const (
+	defaultRetryClasses   = RetryNetworkErrors | RetryServerErrors | RetryThrottled
+	defaultInitialBackoff = 200 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+	defaultJitter         = 0.2
+)
+
+// RetryPolicy applies to the read-only queries and to CreateShipOrder.
+// CreditDetail/DoAction calls are never retried.
+type RetryPolicy struct {
+	// MaxAttempts includes the first call, 0 or 1 disables retries.
+	MaxAttempts int
+	// InitialBackoff doubles after every attempt up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter removes up to this fraction of each backoff at random,
+	// defaults to 0.2. A negative value disables it.
+	Jitter float64
+	// RetryOn defaults to network errors, 5xx and 429 responses.
+	RetryOn RetryClass
+}
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.InitialBackoff
+	if delay <= 0 {
+		delay = defaultInitialBackoff
+	}
+	maxDelay := p.MaxBackoff
+	if maxDelay <= 0 {
+		maxDelay = defaultMaxBackoff
+	}
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	jitter := p.Jitter
+	if jitter == 0 {
+		jitter = defaultJitter
+	}
+	if jitter > 0 {
+		delay -= time.Duration(jitter * rand.Float64() * float64(delay))
+	}
+	return delay
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	classes := p.RetryOn
+	if classes == 0 {
+		classes = defaultRetryClasses
+	}
+	var respErr *ResponseError
+	if errors.As(err, &respErr) {
+		switch {
+		case respErr.Kind == ResponseHTTPStatus && respErr.StatusCode == http.StatusTooManyRequests:
+			return classes&RetryThrottled != 0
+		case respErr.Kind == ResponseHTTPStatus && respErr.StatusCode >= 500:
+			return classes&RetryServerErrors != 0
+		case respErr.Kind == ResponseHTML || respErr.Kind == ResponseEmpty:
+			return classes&RetryMaintenance != 0
+		default:
+			return false
+		}
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) || errors.Is(err, ErrShipOrderMismatch) {
+		return false
+	}
+	return classes&RetryNetworkErrors != 0
+}
+
+// wait sleeps before the given retry attempt, 1 being the first retry.
+func (p RetryPolicy) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(p.backoff(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retry calls fn until it succeeds, fails with an error the policy does
+// not retry, or runs out of attempts. fn gets the 1-based attempt number.
+func (p RetryPolicy) retry(ctx context.Context, fn func(attempt int) error) error {
+	var err error
+	for attempt := 1; attempt <= p.attempts(); attempt++ {
+		if attempt > 1 {
+			if waitErr := p.wait(ctx, attempt-1); waitErr != nil {
+				return errors.Join(waitErr, err)
+			}
+		}
+		err = fn(attempt)
+		if err == nil || ctx.Err() != nil || !p.retryable(err) {
+			return err
+		}
+	}
+	return err
+}
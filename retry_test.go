@@ -0,0 +1,100 @@
+package ecpay_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/img21326/ecpay"
+)
+
+func retryConfig(attempts int, backoff time.Duration) ecpay.EcpayConfig {
+	config := testConfig()
+	config.Retry = ecpay.RetryPolicy{MaxAttempts: attempts, InitialBackoff: backoff, Jitter: -1}
+	return config
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	var calls int32
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "MerchantID=3002607&MerchantTradeNo=T1&TradeNo=2308011200001&TradeAmt=100&TradeStatus=1&PaymentType=Credit_CreditCard&PaymentTypeChargeFee=3")
+	})
+	ec := ecpay.NewEcpay(retryConfig(3, 20*time.Millisecond), ecpay.WithPaymentBaseURL(api.URL))
+
+	start := time.Now()
+	resp, err := ec.QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "T1"})
+	if err != nil {
+		t.Fatalf("QueryPayment: %v", err)
+	}
+	if resp.Status != ecpay.PaymentStatusPaid {
+		t.Errorf("Status = %v, want %v", resp.Status, ecpay.PaymentStatusPaid)
+	}
+	if n := api.count("/Cashier/QueryTradeInfo/V5"); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+	// 20ms, then 40ms.
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("retries took %v, want at least 60ms of backoff", elapsed)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	ec := ecpay.NewEcpay(retryConfig(2, time.Millisecond), ecpay.WithPaymentBaseURL(api.URL))
+
+	_, err := ec.QueryPayment(ecpay.QueryConfig{MerchantTradeNo: "T1"})
+	var respErr *ecpay.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("QueryPayment error = %v, want the 503", err)
+	}
+	if n := api.count("/Cashier/QueryTradeInfo/V5"); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+}
+
+func TestRetryDoesNotRepeatDoAction(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/CreditDetail/DoAction" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, `{"RtnMsg":"","RtnValue":{"status":"已關帳","amount":100,"clsamt":100}}`)
+	})
+	ec := ecpay.NewEcpay(retryConfig(3, time.Millisecond), ecpay.WithPaymentBaseURL(api.URL))
+
+	_, err := ec.RefundPayment(ecpay.RefundConfig{MerchantTradeNo: "T1", BankTransactionID: "1", RefundID: "2", Amount: 100})
+	if err == nil {
+		t.Fatal("RefundPayment succeeded")
+	}
+	if n := api.count("/CreditDetail/DoAction"); n != 1 {
+		t.Errorf("%d DoAction requests, want 1", n)
+	}
+}
+
+func TestRetryDeadlineDuringBackoff(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	ec := ecpay.NewEcpay(retryConfig(3, time.Second), ecpay.WithPaymentBaseURL(api.URL))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := ec.QueryPaymentContext(ctx, ecpay.QueryConfig{MerchantTradeNo: "T1"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("QueryPaymentContext error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("returned after %v, want the deadline to stop the backoff", elapsed)
+	}
+}
@@ -0,0 +1,81 @@
+package ecpay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+var ErrShipOrderMismatch = errors.New("existing ship order does not match")
+
+type ShipOrderMismatchError struct {
+	MerchantTradeNo string
+	Field           string
+	Expected        string
+	Actual          string
+}
+
+func (e *ShipOrderMismatchError) Error() string {
+	return fmt.Sprintf("%v: trade %v %v expected %q, got %q", ErrShipOrderMismatch, e.MerchantTradeNo, e.Field, e.Expected, e.Actual)
+}
+
+func (e *ShipOrderMismatchError) Unwrap() error {
+	return ErrShipOrderMismatch
+}
+
+func (e *EcpayImpl) CreateShipOrderIdempotent(config CreateShippingOrderConfig) (ShipOrderResponse, error) {
+	return e.CreateShipOrderIdempotentContext(context.Background(), config)
+}
+
+// CreateShipOrderIdempotentContext creates the shipment, or returns the
+// shipment ECPay already holds for config.MerchantTradeNo when it was
+// created with the same parameters. A shipment with different parameters
+// fails with a *ShipOrderMismatchError.
+func (e *EcpayImpl) CreateShipOrderIdempotentContext(ctx context.Context, config CreateShippingOrderConfig) (ShipOrderResponse, error) {
+	ctx = withOperation(ctx, "CreateShipOrderIdempotent", config.MerchantTradeNo)
+	resp, err := e.CreateShipOrderContext(ctx, config)
+	if err == nil {
+		return e.ParseShipOrderResponse(resp)
+	}
+	if !errors.Is(err, ErrDuplicateCreateShip) {
+		return ShipOrderResponse{}, err
+	}
+	values, _, err := e.queryShip(ctx, config.MerchantID, config.MerchantTradeNo, true)
+	if err != nil {
+		return ShipOrderResponse{}, err
+	}
+	if err := MatchShipOrder(config, values); err != nil {
+		return ShipOrderResponse{}, err
+	}
+	return e.ParseShipOrderResponse(shipQueryToCreateResponse(values))
+}
+
+// MatchShipOrder compares config with the logistics query of an existing
+// shipment and returns a *ShipOrderMismatchError for the first field that
+// differs. Fields the query leaves empty are not compared.
+func MatchShipOrder(config CreateShippingOrderConfig, values url.Values) error {
+	fields := []struct {
+		name     string
+		expected string
+		actual   string
+	}{
+		{"ShippingStoreType", config.ShippingStoreType, TransferStoreType(values.Get("LogisticsType"))},
+		{"GoodsAmount", config.Amount.String(), values.Get("GoodsAmount")},
+		{"GoodsName", config.EntreeName, values.Get("GoodsName")},
+		{"ReceiverName", config.ReceiverName, values.Get("ReceiverName")},
+		{"ReceiverCellPhone", config.ReceiverPhone, values.Get("ReceiverCellPhone")},
+		{"ReceiverStoreID", config.ReceiverStoreID, values.Get("ReceiverStoreID")},
+	}
+	for _, field := range fields {
+		if field.actual != "" && field.actual != field.expected {
+			return &ShipOrderMismatchError{
+				MerchantTradeNo: config.MerchantTradeNo,
+				Field:           field.name,
+				Expected:        field.expected,
+				Actual:          field.actual,
+			}
+		}
+	}
+	return nil
+}
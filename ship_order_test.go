@@ -0,0 +1,99 @@
+package ecpay_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/img21326/ecpay"
+	"github.com/img21326/ecpay/ecpaytest"
+)
+
+func TestCreateShipOrderIdempotent(t *testing.T) {
+	srv := ecpaytest.NewServer(testConfig())
+	defer srv.Close()
+	ec := srv.Client()
+
+	first, err := ec.CreateShipOrderIdempotent(shipOrderConfig("S1"))
+	if err != nil {
+		t.Fatalf("first create: %v", err)
+	}
+	again, err := ec.CreateShipOrderIdempotent(shipOrderConfig("S1"))
+	if err != nil {
+		t.Fatalf("repeated create: %v", err)
+	}
+	if again.LogisticsID != first.LogisticsID {
+		t.Errorf("repeated create LogisticsID = %q, want %q", again.LogisticsID, first.LogisticsID)
+	}
+
+	changed := shipOrderConfig("S1")
+	changed.ReceiverName = "someone else"
+	_, err = ec.CreateShipOrderIdempotent(changed)
+	var mismatch *ecpay.ShipOrderMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("create with other parameters error = %v, want *ecpay.ShipOrderMismatchError", err)
+	}
+	if mismatch.Field != "ReceiverName" {
+		t.Errorf("mismatch field = %q, want ReceiverName", mismatch.Field)
+	}
+	if !errors.Is(err, ecpay.ErrShipOrderMismatch) {
+		t.Errorf("error = %v, want ErrShipOrderMismatch", err)
+	}
+}
+
+// lostAnswer sends the first request to path and then pretends ECPay
+// answered 503, like a response lost after the shipment was created.
+type lostAnswer struct {
+	path string
+	lost int32
+}
+
+func (l *lostAnswer) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil || req.URL.Path != l.path || !atomic.CompareAndSwapInt32(&l.lost, 0, 1) {
+		return resp, err
+	}
+	resp.Body.Close()
+	return &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestCreateShipOrderRetryAfterLostAnswer(t *testing.T) {
+	config := testConfig()
+	config.Retry = ecpay.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}
+	srv := ecpaytest.NewServer(config)
+	defer srv.Close()
+
+	ec := srv.Client(ecpay.WithTransport(&lostAnswer{path: "/Express/Create"}))
+	body, err := ec.CreateShipOrder(shipOrderConfig("S1"))
+	if err != nil {
+		t.Fatalf("CreateShipOrder: %v", err)
+	}
+	resp, err := ec.ParseShipOrderResponse(body)
+	if err != nil {
+		t.Fatalf("ParseShipOrderResponse: %v", err)
+	}
+	if sh, _ := srv.Shipment("S1"); resp.LogisticsID != sh.LogisticsID {
+		t.Errorf("LogisticsID = %q, want the created shipment %q", resp.LogisticsID, sh.LogisticsID)
+	}
+
+	// S2 exists with another amount: the lost answer is retried, the retry
+	// reports a duplicate and the existing shipment must not be reused.
+	if _, err := srv.Client().CreateShipOrder(shipOrderConfig("S2")); err != nil {
+		t.Fatalf("CreateShipOrder: %v", err)
+	}
+	changed := shipOrderConfig("S2")
+	changed.Amount = 200
+	ec = srv.Client(ecpay.WithTransport(&lostAnswer{path: "/Express/Create"}))
+	if _, err := ec.CreateShipOrder(changed); !errors.Is(err, ecpay.ErrShipOrderMismatch) {
+		t.Errorf("CreateShipOrder of a different S2 error = %v, want ErrShipOrderMismatch", err)
+	}
+}
@@ -1,6 +1,9 @@
 package ecpay
 
-import "strings"
+import (
+	"sort"
+	"strings"
+)
 
 func TransferStoreType(storeType string) string {
 	storeTypes := strings.Split(storeType, "_")
@@ -78,31 +81,39 @@ var OkStatus = map[string]string{
 	"3023": RETURNED,
 }
 
-func TransferStatus(storeType string, rtnCode string) string {
+func statusTable(storeType string) map[string]string {
 	switch storeType {
 	case "FAMI":
-		if val, ok := FamiStatus[rtnCode]; ok {
-			return val
-		}
-		return UNDEFINE
+		return FamiStatus
 	case "711":
-		if val, ok := SevenStatus[rtnCode]; ok {
-			return val
-		}
-		return UNDEFINE
+		return SevenStatus
 	case "HI-LIFI":
-		if val, ok := HiLifeStatus[rtnCode]; ok {
-			return val
-		}
-		return UNDEFINE
+		return HiLifeStatus
 	case "OK":
-		if val, ok := OkStatus[rtnCode]; ok {
-			return val
-		}
-		return UNDEFINE
+		return OkStatus
 	default:
-		return UNDEFINE
+		return nil
+	}
+}
+
+func TransferStatus(storeType string, rtnCode string) string {
+	if val, ok := statusTable(storeType)[rtnCode]; ok {
+		return val
+	}
+	return UNDEFINE
+}
+
+// StatusCodes lists the RtnCodes of storeType that TransferStatus maps to
+// status, in ascending order.
+func StatusCodes(storeType string, status string) []string {
+	var codes []string
+	for code, val := range statusTable(storeType) {
+		if val == status {
+			codes = append(codes, code)
+		}
 	}
+	sort.Strings(codes)
+	return codes
 }
 
 func FormatStoreType(storeType string) string {